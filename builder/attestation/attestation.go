@@ -0,0 +1,145 @@
+package attestation
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"go.codecomet.dev/alkali/builder/run"
+	"go.codecomet.dev/alkali/builder/spec"
+	"go.codecomet.dev/containers/digest"
+)
+
+// DefaultSBOMGenerator is the scanner image buildkit uses by default.
+const DefaultSBOMGenerator = "docker/buildkit-syft-scanner:stable-1"
+
+// Frontend attributes recorded in the provenance invocation parameters, so that an image can be traced back to the
+// run that produced it.
+const (
+	RunIDKey            = "alkali:run-id"
+	DefinitionDigestKey = "alkali:definition-digest"
+)
+
+const (
+	attestPrefix = "attest:"
+
+	provenanceType = "provenance"
+	sbomType       = "sbom"
+)
+
+var (
+	errProvenanceMode = errors.New("invalid provenance mode")
+	errSBOMGenerator  = errors.New("sbom generator cannot be empty")
+)
+
+type ProvenanceMode string
+
+const (
+	ProvenanceMin ProvenanceMode = "min"
+	ProvenanceMax ProvenanceMode = "max"
+)
+
+// Provenance requests a SLSA provenance attestation. Max mode includes the full build definition.
+type Provenance struct {
+	Mode ProvenanceMode
+	// BuilderID is a URI identifying the builder (eg: the CI job)
+	BuilderID    string
+	Reproducible bool
+}
+
+// SBOM requests a software bill of materials, produced by scanning the result with Generator.
+type SBOM struct {
+	Generator string
+}
+
+// Options are the attestations attached to image and OCI exports (requires buildkit 0.11+). Local exports get them
+// as files instead. A nil Options requests nothing.
+type Options struct {
+	Provenance *Provenance
+	SBOM       *SBOM
+}
+
+func NewSBOM() *SBOM {
+	return &SBOM{Generator: DefaultSBOMGenerator}
+}
+
+func NewProvenance(mode ProvenanceMode, builderID string) *Provenance {
+	return &Provenance{Mode: mode, BuilderID: builderID}
+}
+
+// FrontendAttrs returns the solve attributes requesting the attestations, along with invocation metadata from data.
+func (o *Options) FrontendAttrs(data *run.Data) (map[string]string, error) {
+	attrs := map[string]string{}
+
+	if o == nil || o.Provenance == nil && o.SBOM == nil {
+		return attrs, nil
+	}
+
+	if o.Provenance != nil {
+		switch o.Provenance.Mode {
+		case "", ProvenanceMin, ProvenanceMax:
+		default:
+			return nil, fmt.Errorf("%w %q (must be %s or %s)", errProvenanceMode, o.Provenance.Mode, ProvenanceMin,
+				ProvenanceMax)
+		}
+
+		values := map[string]string{}
+
+		if o.Provenance.Mode != "" {
+			values["mode"] = string(o.Provenance.Mode)
+		}
+
+		if o.Provenance.BuilderID != "" {
+			values["builder-id"] = o.Provenance.BuilderID
+		}
+
+		if o.Provenance.Reproducible {
+			values["reproducible"] = "true"
+		}
+
+		attrs[attestPrefix+provenanceType] = formatValues(values)
+	}
+
+	if o.SBOM != nil {
+		if o.SBOM.Generator == "" {
+			return nil, errSBOMGenerator
+		}
+
+		attrs[attestPrefix+sbomType] = formatValues(map[string]string{"generator": o.SBOM.Generator})
+	}
+
+	if data != nil {
+		attrs[RunIDKey] = data.ID
+
+		if data.Protobuf != nil || len(data.Platforms) > 0 {
+			attrs[DefinitionDigestKey] = DefinitionDigest(data).String()
+		}
+	}
+
+	return attrs, nil
+}
+
+// DefinitionDigest is the digest of the serialized definition submitted for that run. For multi-platform runs, it is
+// the digest of the list of platforms along with the digest of their definition.
+func DefinitionDigest(data *run.Data) digest.Digest {
+	if len(data.Platforms) == 0 {
+		return digest.FromBytes(data.Protobuf.Bytes())
+	}
+
+	lines := []string{}
+
+	for _, plt := range data.Platforms {
+		lines = append(lines, plt.ID()+" "+digest.FromBytes(plt.Protobuf.Bytes()).String())
+	}
+
+	return digest.FromBytes([]byte(strings.Join(lines, "\n")))
+}
+
+// formatValues renders the csv value of an attest: attribute (eg: `builder-id=foo,mode=max`)
+func formatValues(values map[string]string) string {
+	if len(values) == 0 {
+		return ""
+	}
+
+	return strings.TrimPrefix(spec.Format("", values), "type=,")
+}
@@ -0,0 +1,395 @@
+package attestation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/platforms"
+	"github.com/containerd/containerd/reference/docker"
+	"github.com/containerd/containerd/remotes"
+	ocispecs "github.com/opencontainers/image-spec/specs-go/v1"
+	"go.codecomet.dev/alkali/builder/registry"
+	"go.codecomet.dev/alkali/builder/run"
+	"go.codecomet.dev/containers/digest"
+)
+
+const (
+	// SLSAProvenance is the predicate type of the provenance buildkit generates
+	SLSAProvenance = "https://slsa.dev/provenance/v0.2"
+
+	predicateTypeAnnotation   = "in-toto.io/predicate-type"
+	referenceTypeAnnotation   = "vnd.docker.reference.type"
+	referenceDigestAnnotation = "vnd.docker.reference.digest"
+	attestationManifestType   = "attestation-manifest"
+
+	dockerImageScheme = "docker-image://"
+	localScheme       = "local://"
+	gitScheme         = "git://"
+	purlDockerPrefix  = "pkg:docker/"
+	dockerHubPrefix   = "docker.io/"
+	dockerHubLibrary  = "library/"
+	defaultTag        = "latest"
+)
+
+// Check is one verification step. Failed checks carry the reason in Detail.
+type Check struct {
+	Name   string
+	Passed bool
+	Detail string
+}
+
+// Report is the result of verifying the provenance of one image manifest (one per platform).
+type Report struct {
+	Subject     digest.Digest
+	Platform    string
+	Attestation digest.Digest
+	BuilderID   string
+	Checks      []*Check
+}
+
+func (o *Report) Passed() bool {
+	for _, check := range o.Checks {
+		if !check.Passed {
+			return false
+		}
+	}
+
+	return len(o.Checks) > 0
+}
+
+func (o *Report) check(name string, passed bool, format string, args ...any) {
+	o.Checks = append(o.Checks, &Check{Name: name, Passed: passed, Detail: fmt.Sprintf(format, args...)})
+}
+
+type material struct {
+	URI    string            `json:"uri"`
+	Digest map[string]string `json:"digest"`
+}
+
+type statement struct {
+	PredicateType string `json:"predicateType"`
+	Subject       []struct {
+		Name   string            `json:"name"`
+		Digest map[string]string `json:"digest"`
+	} `json:"subject"`
+	Predicate struct {
+		Builder struct {
+			ID string `json:"id"`
+		} `json:"builder"`
+		Invocation struct {
+			Parameters struct {
+				Args map[string]string `json:"args"`
+			} `json:"parameters"`
+		} `json:"invocation"`
+		Materials []*material `json:"materials"`
+	} `json:"predicate"`
+}
+
+// blobReader reads the content of descriptors, from an OCI layout or a registry
+type blobReader interface {
+	readBlob(ctx context.Context, desc ocispecs.Descriptor, out any) error
+}
+
+// VerifyOCILayout checks the provenance attached to every image of an OCI layout directory against the run that
+// produced it: the definition digest must match, every source of the definition must be recorded as a material, and
+// no other material may be. This works offline.
+func VerifyOCILayout(path string, data *run.Data) ([]*Report, error) {
+	layout := ociLayout(path)
+
+	var index ocispecs.Index
+	if err := layout.read("index.json", &index); err != nil {
+		return nil, err
+	}
+
+	return verifyAll(context.Background(), layout, index.Manifests, data)
+}
+
+// VerifyRegistry is VerifyOCILayout, for an image pushed to a registry (eg: `docker.io/org/app:1.0`, or
+// `docker.io/org/app@sha256:...`). Use the resolver of the registry.Authenticator the image was pushed with.
+func VerifyRegistry(ctx context.Context, resolver remotes.Resolver, ref string, data *run.Data) ([]*Report, error) {
+	named, err := docker.ParseNormalizedNamed(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	_, desc, err := resolver.Resolve(ctx, docker.TagNameOnly(named).String())
+	if err != nil {
+		return nil, err
+	}
+
+	return verifyAll(ctx, &registryRepository{resolver: resolver, name: named.Name()}, []ocispecs.Descriptor{desc}, data)
+}
+
+func verifyAll(ctx context.Context, reader blobReader, manifests []ocispecs.Descriptor, data *run.Data) ([]*Report, error) {
+	reports := []*Report{}
+
+	for _, desc := range manifests {
+		// Docker manifest lists are indexes too
+		if !images.IsIndexType(desc.MediaType) {
+			// Attestations are only attached to indexes
+			reports = append(reports, missing(desc))
+
+			continue
+		}
+
+		var child ocispecs.Index
+		if err := reader.readBlob(ctx, desc, &child); err != nil {
+			return nil, err
+		}
+
+		attestations := map[digest.Digest]ocispecs.Descriptor{}
+
+		for _, manifest := range child.Manifests {
+			if manifest.Annotations[referenceTypeAnnotation] == attestationManifestType {
+				attestations[digest.Digest(manifest.Annotations[referenceDigestAnnotation])] = manifest
+			}
+		}
+
+		for _, manifest := range child.Manifests {
+			if manifest.Annotations[referenceTypeAnnotation] == attestationManifestType {
+				continue
+			}
+
+			attestation, ok := attestations[manifest.Digest]
+			if !ok {
+				reports = append(reports, missing(manifest))
+
+				continue
+			}
+
+			report, err := verify(ctx, reader, manifest, attestation, data)
+			if err != nil {
+				return nil, err
+			}
+
+			reports = append(reports, report)
+		}
+	}
+
+	return reports, nil
+}
+
+func newReport(desc ocispecs.Descriptor) *Report {
+	report := &Report{Subject: desc.Digest, Checks: []*Check{}}
+	if desc.Platform != nil {
+		report.Platform = platforms.Format(*desc.Platform)
+	}
+
+	return report
+}
+
+func missing(desc ocispecs.Descriptor) *Report {
+	report := newReport(desc)
+	report.check("provenance", false, "no attestation manifest")
+
+	return report
+}
+
+func verify(
+	ctx context.Context,
+	reader blobReader,
+	desc ocispecs.Descriptor,
+	attestation ocispecs.Descriptor,
+	data *run.Data,
+) (*Report, error) {
+	report := newReport(desc)
+	report.Attestation = attestation.Digest
+
+	// Platforms are built from their own definition, and only record its sources
+	id := ""
+	if desc.Platform != nil {
+		id = platforms.Format(platforms.Normalize(*desc.Platform))
+	}
+
+	sources, err := data.GetPlatformSources(id)
+	if run.IsUnknownPlatform(err) {
+		report.check("platform", false, "%s was not built by the run", report.Platform)
+
+		return report, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest ocispecs.Manifest
+	if err = reader.readBlob(ctx, attestation, &manifest); err != nil {
+		return nil, err
+	}
+
+	var stmt *statement
+
+	for _, layer := range manifest.Layers {
+		if layer.Annotations[predicateTypeAnnotation] != SLSAProvenance {
+			continue
+		}
+
+		stmt = &statement{}
+		if err := reader.readBlob(ctx, layer, stmt); err != nil {
+			return nil, err
+		}
+
+		break
+	}
+
+	if stmt == nil {
+		report.check("provenance", false, "no %s predicate in %s", SLSAProvenance, attestation.Digest)
+
+		return report, nil
+	}
+
+	report.check("provenance", true, "%s", SLSAProvenance)
+	report.BuilderID = stmt.Predicate.Builder.ID
+
+	subject := false
+
+	for _, sub := range stmt.Subject {
+		subject = subject || sub.Digest[desc.Digest.Algorithm().String()] == desc.Digest.Encoded()
+	}
+
+	report.check("subject", subject, "statement subjects %s", desc.Digest)
+
+	want := DefinitionDigest(data).String()
+	got := stmt.Predicate.Invocation.Parameters.Args[DefinitionDigestKey]
+	report.check("definition", got == want, "recorded %q, submitted %q", got, want)
+
+	matched := map[*material]bool{}
+
+	for _, src := range sources {
+		if strings.HasPrefix(src, localScheme) {
+			// Local sources are not materials
+			continue
+		}
+
+		found := false
+
+		for _, mat := range stmt.Predicate.Materials {
+			if matchMaterial(src, mat) {
+				matched[mat] = true
+				found = true
+			}
+		}
+
+		report.check("material", found, "source %s", src)
+	}
+
+	for _, mat := range stmt.Predicate.Materials {
+		if !matched[mat] {
+			report.check("material", false, "unexpected material %s", mat.URI)
+		}
+	}
+
+	return report, nil
+}
+
+func matchMaterial(src string, mat *material) bool {
+	switch {
+	case strings.HasPrefix(src, dockerImageScheme):
+		if !strings.HasPrefix(mat.URI, purlDockerPrefix) {
+			return false
+		}
+
+		name, tag, dgst := splitRef(strings.TrimPrefix(src, dockerImageScheme))
+		matName, matVersion := parseDockerPURL(mat.URI)
+
+		if name != matName {
+			return false
+		}
+
+		if dgst != "" {
+			return mat.Digest[dgst.Algorithm().String()] == dgst.Encoded()
+		}
+
+		return tag == matVersion
+	case strings.HasPrefix(src, gitScheme):
+		return normalizeGit(src) == normalizeGit(mat.URI)
+	}
+
+	return src == mat.URI
+}
+
+// splitRef returns the familiar name (as in purls), tag and digest of an image reference
+func splitRef(ref string) (string, string, digest.Digest) {
+	name, dgst, _ := strings.Cut(ref, "@")
+
+	tag := ""
+	if idx := strings.LastIndex(name, ":"); idx > strings.LastIndex(name, "/") {
+		name, tag = name[:idx], name[idx+1:]
+	}
+
+	if tag == "" && dgst == "" {
+		tag = defaultTag
+	}
+
+	name = strings.TrimPrefix(strings.TrimPrefix(name, dockerHubPrefix), dockerHubLibrary)
+
+	return name, tag, digest.Digest(dgst)
+}
+
+// parseDockerPURL returns the name and version of a pkg:docker purl
+func parseDockerPURL(purl string) (string, string) {
+	path, _, _ := strings.Cut(strings.TrimPrefix(purl, purlDockerPrefix), "?")
+
+	name, version := path, ""
+	if idx := strings.LastIndex(path, "@"); idx != -1 {
+		name, version = path[:idx], path[idx+1:]
+	}
+
+	if unescaped, err := url.PathUnescape(name); err == nil {
+		name = unescaped
+	}
+
+	if unescaped, err := url.PathUnescape(version); err == nil {
+		version = unescaped
+	}
+
+	return strings.TrimPrefix(name, dockerHubLibrary), version
+}
+
+// normalizeGit drops the scheme, ref and .git suffix, which differ between source identifiers and recorded remotes
+func normalizeGit(remote string) string {
+	remote, _, _ = strings.Cut(remote, "#")
+	if _, rest, ok := strings.Cut(remote, "://"); ok {
+		remote = rest
+	}
+
+	if _, rest, ok := strings.Cut(remote, "@"); ok {
+		remote = strings.Replace(rest, ":", "/", 1)
+	}
+
+	return strings.TrimSuffix(strings.TrimSuffix(remote, "/"), ".git")
+}
+
+type ociLayout string
+
+func (o ociLayout) read(name string, out any) error {
+	byt, err := os.ReadFile(filepath.Join(string(o), name))
+	if err != nil {
+		return err
+	}
+
+	if err = json.Unmarshal(byt, out); err != nil {
+		return fmt.Errorf("could not unmarshal %s: %w", name, err)
+	}
+
+	return nil
+}
+
+func (o ociLayout) readBlob(_ context.Context, desc ocispecs.Descriptor, out any) error {
+	return o.read(filepath.Join("blobs", desc.Digest.Algorithm().String(), desc.Digest.Encoded()), out)
+}
+
+type registryRepository struct {
+	resolver remotes.Resolver
+	name     string
+}
+
+func (o *registryRepository) readBlob(ctx context.Context, desc ocispecs.Descriptor, out any) error {
+	return registry.FetchJSON(ctx, o.resolver, o.name, desc, out)
+}
@@ -0,0 +1,220 @@
+package attestation_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/moby/buildkit/client/llb"
+	ocispecs "github.com/opencontainers/image-spec/specs-go/v1"
+	"go.codecomet.dev/alkali/builder/attestation"
+	"go.codecomet.dev/alkali/builder/internal/fakeregistry"
+	"go.codecomet.dev/alkali/builder/registry"
+	"go.codecomet.dev/alkali/builder/run"
+	"go.codecomet.dev/containers/digest"
+)
+
+// marshalDefinition returns the protobuf of a definition
+func marshalDefinition(t *testing.T, state llb.State, platform ocispecs.Platform) *bytes.Buffer {
+	t.Helper()
+
+	def, err := state.Marshal(context.Background(), llb.Platform(platform))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	proto := &bytes.Buffer{}
+	if err = llb.WriteTo(def, proto); err != nil {
+		t.Fatal(err)
+	}
+
+	return proto
+}
+
+func putJSON(t *testing.T, reg *fakeregistry.Registry, mediaType string, value any) ocispecs.Descriptor {
+	t.Helper()
+
+	byt, err := json.Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return ocispecs.Descriptor{MediaType: mediaType, Digest: reg.PutBlob("org/app", byt), Size: int64(len(byt))}
+}
+
+func putManifest(t *testing.T, reg *fakeregistry.Registry, tag string, mediaType string, value any) ocispecs.Descriptor {
+	t.Helper()
+
+	byt, err := json.Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return ocispecs.Descriptor{
+		MediaType: mediaType,
+		Digest:    reg.PutManifest("org/app", tag, mediaType, byt),
+		Size:      int64(len(byt)),
+	}
+}
+
+// putProvenance stores the provenance of image, and returns the descriptor of its attestation manifest
+func putProvenance(
+	t *testing.T,
+	reg *fakeregistry.Registry,
+	image digest.Digest,
+	data *run.Data,
+	materials ...map[string]any,
+) ocispecs.Descriptor {
+	t.Helper()
+
+	stmt := map[string]any{
+		"predicateType": attestation.SLSAProvenance,
+		"subject":       []any{map[string]any{"name": "app", "digest": map[string]string{"sha256": image.Encoded()}}},
+		"predicate": map[string]any{
+			"builder": map[string]string{"id": "https://ci.local/job/1"},
+			"invocation": map[string]any{"parameters": map[string]any{"args": map[string]string{
+				attestation.DefinitionDigestKey: attestation.DefinitionDigest(data).String(),
+			}}},
+			"materials": materials,
+		},
+	}
+
+	layer := putJSON(t, reg, "application/vnd.in-toto+json", stmt)
+	layer.Annotations = map[string]string{"in-toto.io/predicate-type": attestation.SLSAProvenance}
+
+	desc := putManifest(t, reg, "", ocispecs.MediaTypeImageManifest, map[string]any{
+		"schemaVersion": 2,
+		"mediaType":     ocispecs.MediaTypeImageManifest,
+		"config":        putJSON(t, reg, ocispecs.MediaTypeImageConfig, map[string]string{}),
+		"layers":        []ocispecs.Descriptor{layer},
+	})
+	desc.Annotations = map[string]string{
+		"vnd.docker.reference.type":   "attestation-manifest",
+		"vnd.docker.reference.digest": image.String(),
+	}
+
+	return desc
+}
+
+func imageMaterial(purl string, content string) map[string]any {
+	return map[string]any{"uri": purl, "digest": map[string]string{"sha256": digest.FromBytes([]byte(content)).Encoded()}}
+}
+
+func TestVerifyRegistry(t *testing.T) {
+	t.Parallel()
+
+	armv7 := ocispecs.Platform{OS: "linux", Architecture: "arm", Variant: "v7"}
+
+	data := run.New(marshalDefinition(t, llb.Image("alpine:3.18"), armv7))
+	t.Cleanup(func() {
+		_ = data.Close()
+	})
+
+	reg, err := fakeregistry.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer reg.Close()
+
+	image := digest.FromBytes([]byte("image"))
+
+	putManifest(t, reg, "1.0", ocispecs.MediaTypeImageIndex, map[string]any{
+		"schemaVersion": 2,
+		"mediaType":     ocispecs.MediaTypeImageIndex,
+		"manifests": []ocispecs.Descriptor{
+			{MediaType: ocispecs.MediaTypeImageManifest, Digest: image, Platform: &armv7},
+			putProvenance(t, reg, image, data, imageMaterial("pkg:docker/alpine@3.18?platform=linux%2Farm%2Fv7", "alpine")),
+		},
+	})
+
+	ref := reg.Host() + "/org/app:1.0"
+
+	reports, err := attestation.VerifyRegistry(context.Background(), registry.New().Resolver(), ref, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(reports) != 1 {
+		t.Fatalf("expected a single report, got %d", len(reports))
+	}
+
+	report := reports[0]
+
+	if report.Platform != "linux/arm/v7" {
+		t.Errorf("expected the platform variant to be kept, got %q", report.Platform)
+	}
+
+	for _, check := range report.Checks {
+		if !check.Passed {
+			t.Errorf("check %s failed: %s", check.Name, check.Detail)
+		}
+	}
+
+	if !report.Passed() || report.BuilderID != "https://ci.local/job/1" {
+		t.Errorf("unexpected report %+v", report)
+	}
+}
+
+func TestVerifyRegistryPlatforms(t *testing.T) {
+	t.Parallel()
+
+	amd64 := ocispecs.Platform{OS: "linux", Architecture: "amd64"}
+	arm64 := ocispecs.Platform{OS: "linux", Architecture: "arm64"}
+
+	// Only the arm64 image needs busybox
+	data := run.New(nil)
+	data.Platforms = []*run.Platform{
+		{Platform: amd64, Protobuf: marshalDefinition(t, llb.Image("alpine:3.18"), amd64)},
+		{Platform: arm64, Protobuf: marshalDefinition(t, llb.Image("alpine:3.18").File(
+			llb.Copy(llb.Image("busybox:1.36"), "/bin/busybox", "/bin/busybox"),
+		), arm64)},
+	}
+
+	t.Cleanup(func() {
+		_ = data.Close()
+	})
+
+	reg, err := fakeregistry.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer reg.Close()
+
+	amd64Image := digest.FromBytes([]byte("amd64"))
+	arm64Image := digest.FromBytes([]byte("arm64"))
+
+	putManifest(t, reg, "1.0", ocispecs.MediaTypeImageIndex, map[string]any{
+		"schemaVersion": 2,
+		"mediaType":     ocispecs.MediaTypeImageIndex,
+		"manifests": []ocispecs.Descriptor{
+			{MediaType: ocispecs.MediaTypeImageManifest, Digest: amd64Image, Platform: &amd64},
+			{MediaType: ocispecs.MediaTypeImageManifest, Digest: arm64Image, Platform: &arm64},
+			putProvenance(t, reg, amd64Image, data,
+				imageMaterial("pkg:docker/alpine@3.18?platform=linux%2Famd64", "alpine")),
+			putProvenance(t, reg, arm64Image, data,
+				imageMaterial("pkg:docker/alpine@3.18?platform=linux%2Farm64", "alpine"),
+				imageMaterial("pkg:docker/busybox@1.36?platform=linux%2Farm64", "busybox")),
+		},
+	})
+
+	reports, err := attestation.VerifyRegistry(
+		context.Background(), registry.New().Resolver(), reg.Host()+"/org/app:1.0", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(reports) != 2 {
+		t.Fatalf("expected a report per platform, got %d", len(reports))
+	}
+
+	for _, report := range reports {
+		for _, check := range report.Checks {
+			if !check.Passed {
+				t.Errorf("%s: check %s failed: %s", report.Platform, check.Name, check.Detail)
+			}
+		}
+	}
+}
@@ -2,6 +2,7 @@ package build
 
 import (
 	"os"
+	"strings"
 
 	"github.com/moby/buildkit/session"
 	"github.com/moby/buildkit/session/secrets/secretsprovider"
@@ -44,6 +45,46 @@ func (o *Options) AddSecret(id string, filepath string, envName string) {
 	})
 }
 
+// GetSecretValues returns the actual content of all registered secrets, so that they can be scrubbed from logs.
+// Secret files that cannot be read are skipped: buildkit reports them when (and if) the build actually needs them.
+func (o *Options) GetSecretValues() []string {
+	values := []string{}
+
+	for _, secret := range o.secrets {
+		if secret.Env != "" {
+			values = appendSecretValue(values, os.Getenv(secret.Env))
+		}
+
+		if secret.FilePath != "" {
+			value, err := os.ReadFile(secret.FilePath)
+			if err != nil {
+				log.Debug().Err(err).Str("id", secret.ID).Msg("Cannot read secret file, not redacting it.")
+
+				continue
+			}
+
+			values = appendSecretValue(values, string(value))
+		}
+	}
+
+	return values
+}
+
+// Secret files frequently come with a trailing newline that will not be part of what ends up being printed
+func appendSecretValue(values []string, value string) []string {
+	if value == "" {
+		return values
+	}
+
+	values = append(values, value)
+
+	if trimmed := strings.TrimSpace(value); trimmed != "" && trimmed != value {
+		values = append(values, trimmed)
+	}
+
+	return values
+}
+
 func (o *Options) AllowNetworkHost(allow bool) {
 	o.entitlements = toggle(o.entitlements, entitlementNetworkHost, allow)
 }
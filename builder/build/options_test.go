@@ -0,0 +1,31 @@
+package build_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"go.codecomet.dev/alkali/builder/build"
+)
+
+func TestGetSecretValues(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "token")
+
+	if err := os.WriteFile(file, []byte("file-secret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := build.New()
+	opts.AddSecret("token", file, "")
+	// Missing files are left for buildkit to report, if the build uses them at all
+	opts.AddSecret("missing", filepath.Join(dir, "missing"), "")
+
+	expected := []string{"file-secret\n", "file-secret"}
+	if values := opts.GetSecretValues(); !reflect.DeepEqual(values, expected) {
+		t.Errorf("expected %q, got %q", expected, values)
+	}
+}
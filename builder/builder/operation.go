@@ -2,14 +2,20 @@ package builder
 
 import (
 	"bytes"
+	"crypto"
 
+	ocispecs "github.com/opencontainers/image-spec/specs-go/v1"
 	"go.codecomet.dev/alkali/builder"
+	"go.codecomet.dev/alkali/builder/attestation"
 	"go.codecomet.dev/alkali/builder/build"
 	"go.codecomet.dev/alkali/builder/cache"
 	"go.codecomet.dev/alkali/builder/exporter"
+	"go.codecomet.dev/alkali/builder/image"
 	"go.codecomet.dev/alkali/builder/registry"
 	"go.codecomet.dev/alkali/builder/run"
+	"go.codecomet.dev/alkali/builder/visibility"
 	"go.codecomet.dev/alkali/machine"
+	"go.codecomet.dev/core/log"
 )
 
 type Operation struct {
@@ -19,16 +25,37 @@ type Operation struct {
 	Export      []exporter.Entry
 	Options     *build.Options
 	Run         *run.Data
+	// Visibility decides which vertexes are hidden from the progress display, traces and reports
+	Visibility *visibility.Rules
+	// Attestations to attach to exported images, if any
+	Attestations *attestation.Options
+	// ImageConfig, if set, is the config of exported images
+	ImageConfig *image.Config
+	// Signer, if set, signs pushed images and exported OCI layouts. Other image exports cannot be signed.
+	Signer crypto.Signer
 
 	// XXX
 	Progress string
 }
 
+// Ingest starts a new run. The logs of the previous run, if any, are released.
 func (o *Operation) Ingest(proto *bytes.Buffer, locals map[string]string) {
+	if o.Run != nil {
+		if err := o.Run.Close(); err != nil {
+			log.Error().Err(err).Msg("Failed releasing the logs of the previous run.")
+		}
+	}
+
 	o.Run = run.New(proto)
 	o.Run.Locals = locals
 }
 
+// IngestPlatform adds the definition to build for platform. Runs with platforms export a multi-platform image, and do
+// not build the definition passed to Ingest.
+func (o *Operation) IngestPlatform(platform ocispecs.Platform, proto *bytes.Buffer) {
+	o.Run.Platforms = append(o.Run.Platforms, &run.Platform{Platform: platform, Protobuf: proto})
+}
+
 func NewOperation(path string) *Operation {
 	return &Operation{
 		Node: &Node{
@@ -41,7 +68,8 @@ func NewOperation(path string) *Operation {
 			Export: []cache.Entry{},
 			Import: []cache.Entry{},
 		},
-		Export: []exporter.Entry{},
-		Run:    run.New(nil),
+		Export:     []exporter.Entry{},
+		Run:        run.New(nil),
+		Visibility: visibility.Default(),
 	}
 }
@@ -0,0 +1,143 @@
+package cache
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/moby/buildkit/client"
+	"go.codecomet.dev/alkali/builder/spec"
+)
+
+var (
+	errAzureAccountURL = errors.New("invalid azure storage account url")
+	errAzureContainer  = errors.New("invalid azure container name")
+	errAzureSecret     = errors.New("invalid azure secret access key")
+	errAzureName       = errors.New("invalid cache manifest name")
+
+	// https://learn.microsoft.com/en-us/rest/api/storageservices/naming-and-referencing-containers--blobs--and-metadata
+	azureContainerName = regexp.MustCompile(`^[a-z0-9](?:[a-z0-9]|-[a-z0-9])*$`) //nolint:gochecknoglobals
+)
+
+const (
+	azureContainerMinLength = 3
+	azureContainerMaxLength = 63
+)
+
+// Azure stores cache in an Azure Blob Storage container.
+// Use NewAzure to get one, as it validates the account url, container and key.
+// If SecretAccessKey is empty, the daemon will use its default azure credentials.
+type Azure struct {
+	AccountURL      string
+	Container       string
+	SecretAccessKey string
+	Prefix          string
+	BlobsPrefix     string
+	ManifestsPrefix string
+	// Names of the cache manifests to import from, or export to. Defaults to "buildkit" on the daemon side.
+	Names       []string
+	Mode        Mode
+	IgnoreError bool
+}
+
+func NewAzure(accountURL string, container string, secretAccessKey string, names ...string) (*Azure, error) {
+	parsed, err := url.Parse(accountURL)
+	if err != nil {
+		return nil, fmt.Errorf("%w %q: %s", errAzureAccountURL, accountURL, err.Error())
+	}
+
+	if parsed.Scheme != "https" && parsed.Scheme != "http" {
+		return nil, fmt.Errorf("%w %q: scheme must be https (or http for a local emulator)", errAzureAccountURL, accountURL)
+	}
+
+	if parsed.Hostname() == "" {
+		return nil, fmt.Errorf("%w %q: no host - expected something like https://<account>.blob.core.windows.net",
+			errAzureAccountURL, accountURL)
+	}
+
+	if len(container) < azureContainerMinLength || len(container) > azureContainerMaxLength {
+		return nil, fmt.Errorf("%w %q: must be between %d and %d characters long",
+			errAzureContainer, container, azureContainerMinLength, azureContainerMaxLength)
+	}
+
+	if !azureContainerName.MatchString(container) {
+		return nil, fmt.Errorf("%w %q: only lowercase letters, numbers and single hyphens are allowed, "+
+			"and it must start and end with a letter or number", errAzureContainer, container)
+	}
+
+	if secretAccessKey != "" {
+		if _, err := base64.StdEncoding.DecodeString(secretAccessKey); err != nil {
+			return nil, fmt.Errorf("%w: shared keys are base64 encoded (%s)", errAzureSecret, err.Error())
+		}
+	}
+
+	for _, name := range names {
+		if name == "" || strings.Contains(name, ";") {
+			return nil, fmt.Errorf("%w %q: must not be empty or contain ';'", errAzureName, name)
+		}
+	}
+
+	return &Azure{
+		AccountURL:      accountURL,
+		Container:       container,
+		SecretAccessKey: secretAccessKey,
+		Names:           names,
+	}, nil
+}
+
+func (o *Azure) attrs() map[string]string {
+	attrs := map[string]string{
+		"account_url": o.AccountURL,
+		"container":   o.Container,
+	}
+
+	optional := map[string]string{
+		"secret_access_key": o.SecretAccessKey,
+		"prefix":            o.Prefix,
+		"blobs_prefix":      o.BlobsPrefix,
+		"manifests_prefix":  o.ManifestsPrefix,
+		"name":              strings.Join(o.Names, ";"),
+	}
+
+	for k, v := range optional {
+		if v != "" {
+			attrs[k] = v
+		}
+	}
+
+	return attrs
+}
+
+func (o *Azure) GetSecretValues() []string {
+	return []string{o.SecretAccessKey}
+}
+
+func (o *Azure) String() string {
+	entry := o.ToExport()
+
+	return spec.Format(entry.Type, entry.Attrs, "secret_access_key")
+}
+
+func (o *Azure) ToImport() client.CacheOptionsEntry {
+	return client.CacheOptionsEntry{
+		Type:  string(typeAzure),
+		Attrs: o.attrs(),
+	}
+}
+
+func (o *Azure) ToExport() client.CacheOptionsEntry {
+	attrs := o.attrs()
+	attrs["ignore-error"] = fmt.Sprintf("%t", o.IgnoreError)
+
+	if o.Mode != "" {
+		attrs["mode"] = string(o.Mode)
+	}
+
+	return client.CacheOptionsEntry{
+		Type:  string(typeAzure),
+		Attrs: attrs,
+	}
+}
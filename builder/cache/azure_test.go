@@ -0,0 +1,41 @@
+package cache_test
+
+import (
+	"testing"
+
+	"go.codecomet.dev/alkali/builder/cache"
+)
+
+func TestNewAzure(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		url       string
+		container string
+		key       string
+		names     []string
+		valid     bool
+	}{
+		{"azure", "https://account.blob.core.windows.net", "buildkit-cache", "", nil, true},
+		{"azurite", "http://127.0.0.1:10000/devstoreaccount1", "cache", "a2V5", []string{"main"}, true},
+		{"scheme", "ftp://account.blob.core.windows.net", "cache", "", nil, false},
+		{"host", "https:///path", "cache", "", nil, false},
+		{"short container", "https://account.blob.core.windows.net", "ab", "", nil, false},
+		{"uppercase container", "https://account.blob.core.windows.net", "Cache", "", nil, false},
+		{"double hyphen", "https://account.blob.core.windows.net", "my--cache", "", nil, false},
+		{"key", "https://account.blob.core.windows.net", "cache", "not base64!", nil, false},
+		{"name", "https://account.blob.core.windows.net", "cache", "", []string{"a;b"}, false},
+	}
+
+	for _, test := range tests {
+		entry, err := cache.NewAzure(test.url, test.container, test.key, test.names...)
+		if test.valid && err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+		}
+
+		if !test.valid && err == nil {
+			t.Errorf("%s: expected an error, got %v", test.name, entry)
+		}
+	}
+}
@@ -1,7 +1,13 @@
 package cache
 
 import (
+	"errors"
+	"fmt"
+	"strconv"
+
 	"github.com/moby/buildkit/client"
+	"go.codecomet.dev/alkali/builder/spec"
+	"go.codecomet.dev/containers/digest"
 )
 
 type cacheType string
@@ -11,24 +17,112 @@ const (
 	typeLocal    cacheType = "local"
 	typeGHA      cacheType = "gha"
 	typeS3       cacheType = "s3"
-	typeAzure    cacheType = "azure"
+	typeAzure    cacheType = "azblob"
+	typeInline   cacheType = "inline"
+)
+
+type Mode string
+
+const (
+	ModeMin Mode = "min"
+	ModeMax Mode = "max"
+)
+
+type Compression string
+
+const (
+	CompressionUncompressed Compression = "uncompressed"
+	CompressionGzip         Compression = "gzip"
+	CompressionEstargz      Compression = "estargz"
+	CompressionZstd         Compression = "zstd"
 )
 
+var errCompressionLevel = errors.New("invalid compression level")
+
+// Level ranges, as accepted by buildkit
+const (
+	maxGzipLevel = 9
+	maxZstdLevel = 22
+)
+
+// ValidateLevel checks that level is in the range allowed for that compression. 0 is always valid, and means default.
+func (o Compression) ValidateLevel(level int) error {
+	if level == 0 {
+		return nil
+	}
+
+	maxLevel := maxGzipLevel
+
+	switch o {
+	case CompressionZstd:
+		maxLevel = maxZstdLevel
+	case "", CompressionUncompressed:
+		return fmt.Errorf("%w: a level requires a compression", errCompressionLevel)
+	case CompressionGzip, CompressionEstargz:
+	}
+
+	if level < 0 || level > maxLevel {
+		return fmt.Errorf("%w %d for %s (must be between 0 and %d)", errCompressionLevel, level, o, maxLevel)
+	}
+
+	return nil
+}
+
 type Entry interface {
 	ToExport() client.CacheOptionsEntry
 	ToImport() client.CacheOptionsEntry
 }
 
+// Entries that cannot work in the current environment (and are allowed to fail) are skipped instead of failing the
+// whole build.
+type disabler interface {
+	Disabled() bool
+}
+
+// Entries holding credentials, which must be scrubbed from logs
+type secretHolder interface {
+	GetSecretValues() []string
+}
+
+func enabled(entry Entry) bool {
+	dis, ok := entry.(disabler)
+
+	return !ok || !dis.Disabled()
+}
+
 type Options struct {
 	NoCache bool
 	Import  []Entry
 	Export  []Entry
+	// History is a file where per-vertex durations are kept across runs, to estimate the time saved by cache hits
+	History string
+	// Sharing, if set, automatically imports and exports cache from and to a backend shared by all nodes
+	Sharing *Sharing
+}
+
+// GetSecretValues returns the credentials of all entries, so that they can be scrubbed from logs.
+func (o *Options) GetSecretValues() []string {
+	values := []string{}
+
+	for _, entry := range append(append([]Entry{}, o.Import...), o.Export...) {
+		if holder, ok := entry.(secretHolder); ok {
+			values = append(values, holder.GetSecretValues()...)
+		}
+	}
+
+	return values
 }
 
 func (o *Options) ToClientImport() []client.CacheOptionsEntry {
 	ret := []client.CacheOptionsEntry{}
 	for _, v := range o.Import {
-		ret = append(ret, v.ToImport())
+		if enabled(v) {
+			ret = append(ret, v.ToImport())
+		}
+	}
+
+	if o.Sharing != nil {
+		ret = append(ret, o.Sharing.toImport()...)
 	}
 
 	return ret
@@ -36,55 +130,112 @@ func (o *Options) ToClientImport() []client.CacheOptionsEntry {
 
 func (o *Options) ToClientExport() []client.CacheOptionsEntry {
 	ret := []client.CacheOptionsEntry{}
-	for _, v := range o.Import {
-		ret = append(ret, v.ToExport())
+	for _, v := range o.Export {
+		if enabled(v) {
+			ret = append(ret, v.ToExport())
+		}
+	}
+
+	if o.Sharing != nil {
+		ret = append(ret, o.Sharing.toExport()...)
 	}
 
 	return ret
 }
 
+// Local stores cache in a directory, as an OCI layout. Zero values keep the previous behavior: uncompressed layers,
+// exported in max mode.
 type Local struct {
-	Path string
+	Path        string
+	Mode        Mode
+	Compression Compression
+	// CompressionLevel is only used if set (0 leaves the buildkit default)
+	CompressionLevel int
+	// ForceCompression recompresses layers that are already compressed with another algorithm
+	ForceCompression bool
+	IgnoreError      bool
+	// Tag names the export in the index, and selects what to import. Digest selects a specific import, and wins over
+	// Tag.
+	Tag    string
+	Digest digest.Digest
+}
+
+func (o *Local) String() string {
+	entry := o.ToExport()
+
+	return spec.Format(entry.Type, entry.Attrs)
 }
 
 func (o *Local) ToImport() client.CacheOptionsEntry {
+	attrs := map[string]string{
+		"src": o.Path,
+	}
+
+	if o.Digest != "" {
+		attrs["digest"] = o.Digest.String()
+	} else if o.Tag != "" {
+		attrs["tag"] = o.Tag
+	}
+
 	return client.CacheOptionsEntry{
-		Type: string(typeLocal),
-		Attrs: map[string]string{
-			"src": o.Path,
-		},
+		Type:  string(typeLocal),
+		Attrs: attrs,
 	}
 }
 
 func (o *Local) ToExport() client.CacheOptionsEntry {
+	attrs := map[string]string{
+		"dest":           o.Path,
+		"compression":    string(CompressionUncompressed),
+		"oci-mediatypes": "true",
+		"mode":           string(ModeMax),
+	}
+
+	if o.Mode != "" {
+		attrs["mode"] = string(o.Mode)
+	}
+
+	if o.Compression != "" {
+		attrs["compression"] = string(o.Compression)
+	}
+
+	if o.CompressionLevel != 0 {
+		attrs["compression-level"] = strconv.Itoa(o.CompressionLevel)
+	}
+
+	if o.ForceCompression {
+		attrs["force-compression"] = "true"
+	}
+
+	if o.IgnoreError {
+		attrs["ignore-error"] = "true"
+	}
+
+	if o.Tag != "" {
+		attrs["tag"] = o.Tag
+	}
+
 	return client.CacheOptionsEntry{
-		Type: string(typeLocal),
-		Attrs: map[string]string{
-			"dest":           o.Path,
-			"compression":    "uncompressed",
-			"oci-mediatypes": "true",
-			"mode":           "max",
-		},
+		Type:  string(typeLocal),
+		Attrs: attrs,
 	}
 }
 
-/*
-func loadGithubEnv(cache client.CacheOptionsEntry) (client.CacheOptionsEntry, error) {
-	if _, ok := cache.Attrs["url"]; !ok {
-		url, ok := os.LookupEnv("ACTIONS_CACHE_URL")
-		if !ok {
-			return cache, errors.New("cache with type gha requires url parameter or $ACTIONS_CACHE_URL")
-		}
-		cache.Attrs["url"] = url
+func (o *Local) Validate(daemonVersion string) error {
+	if err := o.Compression.ValidateLevel(o.CompressionLevel); err != nil {
+		return err
 	}
 
-	if _, ok := cache.Attrs["token"]; !ok {
-		token, ok := os.LookupEnv("ACTIONS_RUNTIME_TOKEN")
-		if !ok {
-			return cache, errors.New("cache with type gha requires token parameter or $ACTIONS_RUNTIME_TOKEN")
+	if o.Compression != "" && o.Compression != CompressionUncompressed || o.CompressionLevel != 0 || o.ForceCompression {
+		if err := requireVersion(daemonVersion, "v0.10.0", "local cache compression"); err != nil {
+			return err
 		}
-		cache.Attrs["token"] = token
 	}
-	return cache, nil
+
+	// Tags are resolved from the index by the client, only ignore-error has to be understood by the daemon
+	if o.IgnoreError {
+		return requireVersion(daemonVersion, "v0.11.0", "local cache ignore-error")
+	}
+
+	return nil
 }
-*/
@@ -0,0 +1,114 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/moby/buildkit/client"
+	"go.codecomet.dev/alkali/builder/spec"
+	"go.codecomet.dev/core/log"
+)
+
+var errGHAEnv = errors.New("cache with type gha requires url and token, or $ACTIONS_CACHE_URL and $ACTIONS_RUNTIME_TOKEN " +
+	"(are you running inside GitHub Actions?)")
+
+// GHA stores cache in the GitHub Actions cache service.
+type GHA struct {
+	URL   string
+	Token string
+	// Scope partitions the cache - defaults to "buildkit" on the daemon side
+	Scope       string
+	Mode        Mode
+	IgnoreError bool
+
+	disabled bool
+}
+
+// NewGHA autodetects the cache service url and token from the GitHub Actions environment.
+// Outside of Actions, an error is returned, unless ignoreError is set, in which case the entry is simply disabled.
+func NewGHA(scope string, ignoreError bool) (*GHA, error) {
+	return newGHA(os.Getenv("ACTIONS_CACHE_URL"), os.Getenv("ACTIONS_RUNTIME_TOKEN"), scope, ignoreError)
+}
+
+func newGHA(url string, token string, scope string, ignoreError bool) (*GHA, error) {
+	entry := &GHA{
+		URL:         url,
+		Token:       token,
+		Scope:       scope,
+		IgnoreError: ignoreError,
+	}
+
+	if !entry.configured() {
+		if !ignoreError {
+			return nil, errGHAEnv
+		}
+
+		log.Warn().Err(errGHAEnv).Msg("GitHub Actions cache disabled")
+
+		entry.disabled = true
+	}
+
+	return entry, nil
+}
+
+// Disabled is only true for entries ignoring errors: others fail validation instead of being silently skipped.
+func (o *GHA) Disabled() bool {
+	return o.disabled || (o.IgnoreError && !o.configured())
+}
+
+func (o *GHA) Validate(_ string) error {
+	if !o.configured() {
+		return errGHAEnv
+	}
+
+	return nil
+}
+
+func (o *GHA) configured() bool {
+	return o.URL != "" && o.Token != ""
+}
+
+func (o *GHA) GetSecretValues() []string {
+	return []string{o.Token}
+}
+
+func (o *GHA) attrs() map[string]string {
+	attrs := map[string]string{
+		"url":   o.URL,
+		"token": o.Token,
+	}
+
+	if o.Scope != "" {
+		attrs["scope"] = o.Scope
+	}
+
+	return attrs
+}
+
+func (o *GHA) String() string {
+	entry := o.ToExport()
+
+	return spec.Format(entry.Type, entry.Attrs, "token")
+}
+
+func (o *GHA) ToImport() client.CacheOptionsEntry {
+	return client.CacheOptionsEntry{
+		Type:  string(typeGHA),
+		Attrs: o.attrs(),
+	}
+}
+
+func (o *GHA) ToExport() client.CacheOptionsEntry {
+	attrs := o.attrs()
+	attrs["ignore-error"] = fmt.Sprintf("%t", o.IgnoreError)
+
+	if o.Mode != "" {
+		attrs["mode"] = string(o.Mode)
+	}
+
+	return client.CacheOptionsEntry{
+		Type:  string(typeGHA),
+		Attrs: attrs,
+	}
+}
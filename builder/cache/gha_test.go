@@ -0,0 +1,60 @@
+package cache_test
+
+import (
+	"testing"
+
+	"go.codecomet.dev/alkali/builder/cache"
+)
+
+func TestGHAOutsideActions(t *testing.T) {
+	t.Setenv("ACTIONS_CACHE_URL", "")
+	t.Setenv("ACTIONS_RUNTIME_TOKEN", "")
+
+	if _, err := cache.NewGHA("main", false); err == nil {
+		t.Error("expected an error outside of GitHub Actions")
+	}
+
+	ignored, err := cache.NewGHA("main", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := &cache.Options{Import: []cache.Entry{ignored}, Export: []cache.Entry{ignored}}
+	if len(opts.ToClientImport()) != 0 || len(opts.ToClientExport()) != 0 {
+		t.Error("expected an entry ignoring errors to be disabled")
+	}
+
+	if err = opts.Validate(""); err != nil {
+		t.Errorf("expected a disabled entry to be valid, got %v", err)
+	}
+
+	// Entries built directly are not silently skipped
+	opts = &cache.Options{Import: []cache.Entry{}, Export: []cache.Entry{&cache.GHA{Scope: "main"}}}
+	if err = opts.Validate(""); err == nil {
+		t.Error("expected an error for an entry without url and token")
+	}
+}
+
+func TestGHASpec(t *testing.T) {
+	t.Setenv("ACTIONS_CACHE_URL", "http://localhost:1234/")
+	t.Setenv("ACTIONS_RUNTIME_TOKEN", "token")
+
+	entry, err := cache.Parse("type=gha,scope=main,mode=max")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	attrs := entry.ToExport().Attrs
+	if attrs["url"] != "http://localhost:1234/" || attrs["token"] != "token" || attrs["scope"] != "main" {
+		t.Errorf("unexpected attributes %v", attrs)
+	}
+
+	opts := &cache.Options{Import: []cache.Entry{}, Export: []cache.Entry{entry}}
+	if err = opts.Validate(""); err != nil {
+		t.Error(err)
+	}
+
+	if secrets := opts.GetSecretValues(); !contains(secrets, "token") {
+		t.Errorf("expected the token to be redacted, got %v", secrets)
+	}
+}
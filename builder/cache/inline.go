@@ -0,0 +1,58 @@
+package cache
+
+import (
+	"errors"
+
+	"github.com/moby/buildkit/client"
+	"go.codecomet.dev/alkali/builder/spec"
+)
+
+var errInlineRef = errors.New("inline cache import requires a ref")
+
+// Inline embeds cache metadata into the image itself, so that it can be reused without a dedicated cache registry.
+// Exporting only works along with an exporter writing an image config (image, docker or oci), and only supports min
+// mode. Importing pulls the metadata from the image reference.
+type Inline struct {
+	// Ref is the image to import cache from - typically, the name the image is pushed as
+	Ref string
+}
+
+func (o *Inline) String() string {
+	return spec.Format(string(typeInline), map[string]string{"ref": o.Ref})
+}
+
+func (o *Inline) ToImport() client.CacheOptionsEntry {
+	return client.CacheOptionsEntry{
+		Type: string(typeRegistry),
+		Attrs: map[string]string{
+			"ref": o.Ref,
+		},
+	}
+}
+
+func (o *Inline) ToExport() client.CacheOptionsEntry {
+	return client.CacheOptionsEntry{
+		Type:  string(typeInline),
+		Attrs: map[string]string{},
+	}
+}
+
+// ValidateImport checks there is an image to import from. Exports need none.
+func (o *Inline) ValidateImport() error {
+	if o.Ref == "" {
+		return errInlineRef
+	}
+
+	return nil
+}
+
+// HasInline tells whether the export list requests inline cache.
+func HasInline(entries []client.CacheOptionsEntry) bool {
+	for _, entry := range entries {
+		if entry.Type == string(typeInline) {
+			return true
+		}
+	}
+
+	return false
+}
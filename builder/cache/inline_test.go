@@ -0,0 +1,26 @@
+package cache_test
+
+import (
+	"testing"
+
+	"go.codecomet.dev/alkali/builder/cache"
+)
+
+func TestInlineValidate(t *testing.T) {
+	t.Parallel()
+
+	opts := &cache.Options{Import: []cache.Entry{}, Export: []cache.Entry{&cache.Inline{}}}
+	if err := opts.Validate(""); err != nil {
+		t.Errorf("inline exports need no ref: %v", err)
+	}
+
+	opts.Import = []cache.Entry{&cache.Inline{}}
+	if err := opts.Validate(""); err == nil {
+		t.Error("expected an inline import without ref to be refused")
+	}
+
+	opts.Import = []cache.Entry{&cache.Inline{Ref: "registry.local/app:latest"}}
+	if err := opts.Validate(""); err != nil {
+		t.Error(err)
+	}
+}
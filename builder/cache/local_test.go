@@ -0,0 +1,31 @@
+package cache_test
+
+import (
+	"testing"
+
+	"go.codecomet.dev/alkali/builder/cache"
+)
+
+func TestLocalValidate(t *testing.T) {
+	t.Parallel()
+
+	for _, test := range []struct {
+		name          string
+		entry         *cache.Local
+		daemonVersion string
+		valid         bool
+	}{
+		{"tag on old daemon", &cache.Local{Path: "cache", Tag: "main"}, "v0.9.3", true},
+		{"ignore-error on old daemon", &cache.Local{Path: "cache", IgnoreError: true}, "v0.10.6", false},
+		{"ignore-error", &cache.Local{Path: "cache", IgnoreError: true}, "v0.11.6", true},
+		{"ignore-error on prerelease", &cache.Local{Path: "cache", IgnoreError: true}, "v0.11.0-rc1", true},
+		{"ignore-error on unknown version", &cache.Local{Path: "cache", IgnoreError: true}, "", true},
+		{"ignore-error on garbage version", &cache.Local{Path: "cache", IgnoreError: true}, "dev", true},
+		{"compression on old daemon", &cache.Local{Path: "cache", Compression: cache.CompressionZstd}, "v0.9.3", false},
+		{"compression", &cache.Local{Path: "cache", Compression: cache.CompressionZstd}, "v0.10.0", true},
+	} {
+		if err := test.entry.Validate(test.daemonVersion); (err == nil) != test.valid {
+			t.Errorf("%s: unexpected result %v", test.name, err)
+		}
+	}
+}
@@ -0,0 +1,530 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/gofrs/flock"
+	ocispecs "github.com/opencontainers/image-spec/specs-go/v1"
+	"go.codecomet.dev/containers/digest"
+)
+
+const (
+	indexFile      = "index.json"
+	lockFileSuffix = ".lock"
+	blobsDir       = "blobs"
+
+	lockRetryDelay = 100 * time.Millisecond
+
+	// Tag buildkit uses when exporting without one
+	defaultLocalTag = "latest"
+
+	// DefaultBlobGracePeriod protects blobs that may have just been written by a build still in progress
+	DefaultBlobGracePeriod = time.Hour
+)
+
+var errLocked = errors.New("could not lock local cache")
+
+// LocalManifest is one cache export recorded in the index of a local cache directory.
+type LocalManifest struct {
+	Tag    string
+	Digest digest.Digest
+	// Created is when the manifest was last exported or imported (see Touch)
+	Created time.Time
+	Age     time.Duration
+	// Size of all blobs referenced by that manifest (blobs shared with other manifests included)
+	Size int64
+}
+
+type LocalUsage struct {
+	// Size of all blobs on disk, referenced or not
+	Size      int64
+	Manifests []*LocalManifest
+}
+
+// LocalPrunePolicy decides which manifests to keep, newest first. Zero values disable the corresponding limit.
+type LocalPrunePolicy struct {
+	// MaxSize is the total size of blobs referenced by the kept manifests
+	MaxSize  int64
+	MaxAge   time.Duration
+	KeepLast int
+	// Unreferenced blobs more recent than that are left alone. Defaults to DefaultBlobGracePeriod.
+	BlobGracePeriod time.Duration
+}
+
+type LocalPruneResult struct {
+	Removed   []*LocalManifest
+	Reclaimed int64
+}
+
+// LocalStore manages a local cache directory (an OCI layout), as written by Local.
+// The index is locked the same way buildkit does, so that concurrent runs sharing the directory do not corrupt it.
+type LocalStore struct {
+	Path string
+}
+
+func NewLocalStore(path string) *LocalStore {
+	return &LocalStore{Path: path}
+}
+
+func (o *Local) Store() *LocalStore {
+	return NewLocalStore(o.Path)
+}
+
+// TouchLocal marks the local cache manifests a successful run imported or exported as used (see LocalStore.Touch).
+func (o *Options) TouchLocal() error {
+	refs := map[string][]string{}
+
+	for _, entry := range o.Import {
+		local, ok := entry.(*Local)
+		if !ok {
+			continue
+		}
+
+		ref := local.tag()
+		if local.Digest != "" {
+			ref = local.Digest.String()
+		}
+
+		refs[local.Path] = append(refs[local.Path], ref)
+	}
+
+	for _, entry := range o.Export {
+		if local, ok := entry.(*Local); ok {
+			refs[local.Path] = append(refs[local.Path], local.tag())
+		}
+	}
+
+	if o.Sharing != nil && o.Sharing.backend == typeLocal {
+		refs[o.Sharing.Target] = append(refs[o.Sharing.Target], o.Sharing.keys()...)
+	}
+
+	for path, pathRefs := range refs {
+		if err := NewLocalStore(path).Touch(pathRefs...); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (o *Local) tag() string {
+	if o.Tag == "" {
+		return defaultLocalTag
+	}
+
+	return o.Tag
+}
+
+// Inspect reports the size of the store and of each manifest.
+func (o *LocalStore) Inspect(ctx context.Context) (*LocalUsage, error) {
+	lock := flock.New(filepath.Join(o.Path, indexFile+lockFileSuffix))
+
+	locked, err := lock.TryRLockContext(ctx, lockRetryDelay)
+	if err != nil || !locked {
+		return nil, fmt.Errorf("%w %s: %v", errLocked, o.Path, err)
+	}
+
+	defer o.unlock(lock)
+
+	index, err := o.readIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	manifests, _, err := o.manifests(index)
+	if err != nil {
+		return nil, err
+	}
+
+	size, err := o.diskUsage()
+	if err != nil {
+		return nil, err
+	}
+
+	return &LocalUsage{
+		Size:      size,
+		Manifests: manifests,
+	}, nil
+}
+
+// Prune removes manifests outside of the policy from the index, then deletes blobs no longer referenced.
+// The index is only locked while it is rewritten and while blobs are deleted, as buildkit gives up exporting if it
+// cannot lock it right away. Blobs written by exports running during the sweep are protected by the grace period.
+func (o *LocalStore) Prune(ctx context.Context, policy LocalPrunePolicy) (*LocalPruneResult, error) {
+	if policy.BlobGracePeriod == 0 {
+		policy.BlobGracePeriod = DefaultBlobGracePeriod
+	}
+
+	result, err := o.pruneIndex(ctx, policy)
+	if err != nil {
+		return nil, err
+	}
+
+	result.Reclaimed, err = o.sweep(ctx, policy.BlobGracePeriod)
+
+	return result, err
+}
+
+// pruneIndex removes manifests outside of the policy from the index
+func (o *LocalStore) pruneIndex(ctx context.Context, policy LocalPrunePolicy) (*LocalPruneResult, error) {
+	lock := flock.New(filepath.Join(o.Path, indexFile+lockFileSuffix))
+
+	locked, err := lock.TryLockContext(ctx, lockRetryDelay)
+	if err != nil || !locked {
+		return nil, fmt.Errorf("%w %s: %v", errLocked, o.Path, err)
+	}
+
+	defer o.unlock(lock)
+
+	index, err := o.readIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	manifests, blobs, err := o.manifests(index)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &LocalPruneResult{Removed: []*LocalManifest{}}
+	kept := map[digest.Digest]bool{}
+	referenced := map[digest.Digest]bool{}
+
+	var keptSize int64
+
+	// Manifests are sorted newest first
+	for idx, manifest := range manifests {
+		added := int64(0)
+
+		for dgst, size := range blobs[manifest.Digest] {
+			if !referenced[dgst] {
+				added += size
+			}
+		}
+
+		if policy.KeepLast > 0 && idx >= policy.KeepLast ||
+			policy.MaxAge > 0 && manifest.Age > policy.MaxAge ||
+			policy.MaxSize > 0 && keptSize+added > policy.MaxSize {
+			result.Removed = append(result.Removed, manifest)
+
+			continue
+		}
+
+		kept[manifest.Digest] = true
+		keptSize += added
+
+		for dgst := range blobs[manifest.Digest] {
+			referenced[dgst] = true
+		}
+	}
+
+	if len(result.Removed) > 0 {
+		remaining := []ocispecs.Descriptor{}
+
+		for _, desc := range index.Manifests {
+			if kept[desc.Digest] {
+				remaining = append(remaining, desc)
+			}
+		}
+
+		index.Manifests = remaining
+
+		if err = o.writeIndex(index); err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// Touch marks the manifests that tags (or digests) point to as used now. Exporting identical cache again leaves the
+// manifest blob untouched, and importing writes nothing: without this, manifests still in use would age out.
+func (o *LocalStore) Touch(refs ...string) error {
+	index, err := o.readIndex()
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	for _, desc := range index.Manifests {
+		for _, ref := range refs {
+			if desc.Annotations[ocispecs.AnnotationRefName] != ref && desc.Digest.String() != ref {
+				continue
+			}
+
+			if err = os.Chtimes(o.blobPath(desc.Digest), now, now); err != nil {
+				return err
+			}
+
+			break
+		}
+	}
+
+	return nil
+}
+
+// Has tells whether the index has a manifest with that tag. A missing or unreadable index has nothing.
+func (o *LocalStore) Has(tag string) bool {
+	index, err := o.readIndex()
+	if err != nil {
+		return false
+	}
+
+	for _, desc := range index.Manifests {
+		if desc.Annotations[ocispecs.AnnotationRefName] == tag {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (o *LocalStore) unlock(lock *flock.Flock) {
+	_ = lock.Unlock()
+	// Same as buildkit
+	_ = os.RemoveAll(lock.Path())
+}
+
+func (o *LocalStore) readIndex() (*ocispecs.Index, error) {
+	byt, err := os.ReadFile(filepath.Join(o.Path, indexFile))
+	if err != nil {
+		return nil, err
+	}
+
+	var index ocispecs.Index
+	if err := json.Unmarshal(byt, &index); err != nil {
+		return nil, fmt.Errorf("could not unmarshal %s: %w", indexFile, err)
+	}
+
+	return &index, nil
+}
+
+func (o *LocalStore) writeIndex(index *ocispecs.Index) error {
+	byt, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(o.Path, indexFile+".*")
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(byt); err != nil {
+		tmp.Close()
+
+		return err
+	}
+
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), filepath.Join(o.Path, indexFile))
+}
+
+func (o *LocalStore) blobPath(dgst digest.Digest) string {
+	return filepath.Join(o.Path, blobsDir, dgst.Algorithm().String(), dgst.Encoded())
+}
+
+// manifests returns the manifests of the index, newest first, along with the blobs (and their size) each references
+func (o *LocalStore) manifests(
+	index *ocispecs.Index,
+) ([]*LocalManifest, map[digest.Digest]map[digest.Digest]int64, error) {
+	now := time.Now()
+	manifests := []*LocalManifest{}
+	blobs := map[digest.Digest]map[digest.Digest]int64{}
+
+	for _, desc := range index.Manifests {
+		info, err := os.Stat(o.blobPath(desc.Digest))
+		if err != nil {
+			return nil, nil, err
+		}
+
+		refs := map[digest.Digest]int64{}
+		if err := o.walk(desc, refs); err != nil {
+			return nil, nil, err
+		}
+
+		manifest := &LocalManifest{
+			Tag:     desc.Annotations[ocispecs.AnnotationRefName],
+			Digest:  desc.Digest,
+			Created: info.ModTime(),
+			Age:     now.Sub(info.ModTime()),
+		}
+
+		for _, size := range refs {
+			manifest.Size += size
+		}
+
+		blobs[desc.Digest] = refs
+		manifests = append(manifests, manifest)
+	}
+
+	sort.SliceStable(manifests, func(i, j int) bool {
+		return manifests[i].Created.After(manifests[j].Created)
+	})
+
+	return manifests, blobs, nil
+}
+
+// walk records a descriptor and everything it points to (for indexes and manifests)
+func (o *LocalStore) walk(desc ocispecs.Descriptor, refs map[digest.Digest]int64) error {
+	if _, ok := refs[desc.Digest]; ok {
+		return nil
+	}
+
+	refs[desc.Digest] = desc.Size
+
+	// Layers and configs are not worth reading
+	if !isManifestOrIndex(desc.MediaType) {
+		return nil
+	}
+
+	byt, err := os.ReadFile(o.blobPath(desc.Digest))
+	if err != nil {
+		return err
+	}
+
+	var children struct {
+		Manifests []ocispecs.Descriptor `json:"manifests"`
+		Config    *ocispecs.Descriptor  `json:"config"`
+		Layers    []ocispecs.Descriptor `json:"layers"`
+	}
+
+	if err := json.Unmarshal(byt, &children); err != nil {
+		return fmt.Errorf("could not unmarshal %s: %w", desc.Digest, err)
+	}
+
+	all := append(children.Manifests, children.Layers...) //nolint:gocritic
+	if children.Config != nil {
+		all = append(all, *children.Config)
+	}
+
+	for _, child := range all {
+		if err := o.walk(child, refs); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func isManifestOrIndex(mediaType string) bool {
+	switch mediaType {
+	case ocispecs.MediaTypeImageIndex, ocispecs.MediaTypeImageManifest,
+		"application/vnd.docker.distribution.manifest.list.v2+json",
+		"application/vnd.docker.distribution.manifest.v2+json":
+		return true
+	}
+
+	return false
+}
+
+func (o *LocalStore) diskUsage() (int64, error) {
+	var size int64
+
+	err := filepath.WalkDir(filepath.Join(o.Path, blobsDir), func(_ string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		size += info.Size()
+
+		return nil
+	})
+
+	return size, err
+}
+
+// sweep deletes unreferenced blobs older than the grace period, and returns how many bytes were reclaimed. Old blobs
+// are listed first, then the index is read again under lock: exports finished in the meantime may reference old blobs
+// again (without touching them), and those must be kept.
+func (o *LocalStore) sweep(ctx context.Context, grace time.Duration) (int64, error) {
+	cutoff := time.Now().Add(-grace)
+	root := filepath.Join(o.Path, blobsDir)
+	candidates := map[digest.Digest]fs.FileInfo{}
+
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		if info.ModTime().Before(cutoff) {
+			candidates[digest.Digest(filepath.Dir(rel)+":"+filepath.Base(rel))] = info
+		}
+
+		return nil
+	})
+	if err != nil || len(candidates) == 0 {
+		return 0, err
+	}
+
+	lock := flock.New(filepath.Join(o.Path, indexFile+lockFileSuffix))
+
+	locked, err := lock.TryLockContext(ctx, lockRetryDelay)
+	if err != nil || !locked {
+		return 0, fmt.Errorf("%w %s: %v", errLocked, o.Path, err)
+	}
+
+	defer o.unlock(lock)
+
+	index, err := o.readIndex()
+	if err != nil {
+		return 0, err
+	}
+
+	referenced := map[digest.Digest]int64{}
+
+	for _, desc := range index.Manifests {
+		if err = o.walk(desc, referenced); err != nil {
+			return 0, err
+		}
+	}
+
+	var reclaimed int64
+
+	for dgst, info := range candidates {
+		if _, ok := referenced[dgst]; ok {
+			continue
+		}
+
+		if err = os.Remove(o.blobPath(dgst)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return reclaimed, err
+		}
+
+		reclaimed += info.Size()
+	}
+
+	return reclaimed, nil
+}
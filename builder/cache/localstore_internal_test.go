@@ -0,0 +1,103 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	ocispecs "github.com/opencontainers/image-spec/specs-go/v1"
+	"go.codecomet.dev/containers/digest"
+)
+
+func putBlob(t *testing.T, store *LocalStore, mediaType string, data []byte, modified time.Time) ocispecs.Descriptor {
+	t.Helper()
+
+	desc := ocispecs.Descriptor{MediaType: mediaType, Digest: digest.FromBytes(data), Size: int64(len(data))}
+	path := store.blobPath(desc.Digest)
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Chtimes(path, modified, modified); err != nil {
+		t.Fatal(err)
+	}
+
+	return desc
+}
+
+func putManifest(
+	t *testing.T,
+	store *LocalStore,
+	tag string,
+	modified time.Time,
+	layers ...ocispecs.Descriptor,
+) ocispecs.Descriptor {
+	t.Helper()
+
+	byt, err := json.Marshal(ocispecs.Manifest{MediaType: ocispecs.MediaTypeImageManifest, Layers: layers})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	desc := putBlob(t, store, ocispecs.MediaTypeImageManifest, byt, modified)
+	desc.Annotations = map[string]string{ocispecs.AnnotationRefName: tag}
+
+	return desc
+}
+
+func TestLocalStoreSweepConcurrentExport(t *testing.T) {
+	t.Parallel()
+
+	store := NewLocalStore(t.TempDir())
+	old := time.Now().Add(-48 * time.Hour)
+
+	shared := putBlob(t, store, ocispecs.MediaTypeImageLayer, []byte("shared layer"), old)
+	stale := putManifest(t, store, "stale", old, shared)
+	kept := putManifest(t, store, "kept", time.Now(),
+		putBlob(t, store, ocispecs.MediaTypeImageLayer, []byte("layer"), time.Now()))
+
+	if err := store.writeIndex(&ocispecs.Index{Manifests: []ocispecs.Descriptor{stale, kept}}); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := store.pruneIndex(context.Background(), LocalPrunePolicy{KeepLast: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(result.Removed) != 1 || result.Removed[0].Tag != "stale" {
+		t.Fatalf("unexpected removed manifests %+v", result.Removed)
+	}
+
+	// An export finishing before the sweep reuses the old layer already on disk, without touching it
+	index, err := store.readIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	index.Manifests = append(index.Manifests, putManifest(t, store, "new", time.Now(), shared,
+		putBlob(t, store, ocispecs.MediaTypeImageLayer, []byte("new layer"), time.Now())))
+	if err = store.writeIndex(index); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = store.sweep(context.Background(), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = os.Stat(store.blobPath(shared.Digest)); err != nil {
+		t.Errorf("expected the layer referenced by the new export to be kept: %v", err)
+	}
+
+	if _, err = os.Stat(store.blobPath(stale.Digest)); !os.IsNotExist(err) {
+		t.Errorf("expected the pruned manifest to be swept, got %v", err)
+	}
+}
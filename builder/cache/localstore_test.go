@@ -0,0 +1,109 @@
+package cache_test
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	ocispecs "github.com/opencontainers/image-spec/specs-go/v1"
+	"go.codecomet.dev/alkali/builder/cache"
+	"go.codecomet.dev/containers/digest"
+)
+
+// writeBlob writes a blob in the layout, with a given modification time
+func writeBlob(t *testing.T, root string, data []byte, modified time.Time) ocispecs.Descriptor {
+	t.Helper()
+
+	dgst := digest.FromBytes(data)
+	path := filepath.Join(root, "blobs", dgst.Algorithm().String(), dgst.Encoded())
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Chtimes(path, modified, modified); err != nil {
+		t.Fatal(err)
+	}
+
+	return ocispecs.Descriptor{Digest: dgst, Size: int64(len(data))}
+}
+
+// writeManifest writes a manifest with a single layer, tagged in the index
+func writeManifest(t *testing.T, root string, tag string, modified time.Time) ocispecs.Descriptor {
+	t.Helper()
+
+	layer := writeBlob(t, root, []byte("layer of "+tag), modified)
+	layer.MediaType = ocispecs.MediaTypeImageLayer
+
+	byt, err := json.Marshal(ocispecs.Manifest{MediaType: ocispecs.MediaTypeImageManifest, Layers: []ocispecs.Descriptor{layer}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	desc := writeBlob(t, root, byt, modified)
+	desc.MediaType = ocispecs.MediaTypeImageManifest
+	desc.Annotations = map[string]string{ocispecs.AnnotationRefName: tag}
+
+	return desc
+}
+
+func writeIndex(t *testing.T, root string, manifests ...ocispecs.Descriptor) {
+	t.Helper()
+
+	byt, err := json.Marshal(ocispecs.Index{MediaType: ocispecs.MediaTypeImageIndex, Manifests: manifests})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = os.WriteFile(filepath.Join(root, "index.json"), byt, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLocalStoreTouch(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	old := time.Now().Add(-48 * time.Hour)
+
+	writeIndex(t, root, writeManifest(t, root, "main", old), writeManifest(t, root, "feature", old))
+
+	// Exporting main again, identical, rewrites nothing but the index
+	opts := &cache.Options{
+		Import: []cache.Entry{},
+		Export: []cache.Entry{&cache.Local{Path: root, Tag: "main"}},
+	}
+
+	if err := opts.TouchLocal(); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := cache.NewLocalStore(root).Prune(context.Background(), cache.LocalPrunePolicy{MaxAge: 24 * time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(result.Removed) != 1 || result.Removed[0].Tag != "feature" {
+		t.Fatalf("expected only the unused manifest to be removed, got %+v", result.Removed)
+	}
+
+	if result.Reclaimed == 0 {
+		t.Error("expected blobs of the removed manifest to be deleted")
+	}
+
+	usage, err := cache.NewLocalStore(root).Inspect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(usage.Manifests) != 1 || usage.Manifests[0].Tag != "main" {
+		t.Errorf("unexpected manifests left %+v", usage.Manifests)
+	}
+}
@@ -0,0 +1,270 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+
+	"go.codecomet.dev/alkali/builder/spec"
+	"go.codecomet.dev/containers/digest"
+)
+
+var (
+	errUnknownType = errors.New("unknown cache type")
+	errLocalPath   = errors.New("local cache requires src or dest (and both must match if set)")
+	errInvalidMode = errors.New("invalid cache mode")
+	errCompression = errors.New("invalid compression")
+)
+
+// Parse reads a buildctl-style cache spec, like `type=registry,ref=example.com/foo/cache,mode=max` or
+// `type=local,dest=path`. Unknown keys are rejected. Where buildctl would, missing values are read from the environment.
+func Parse(value string) (Entry, error) {
+	typ, attrs, err := spec.Parse(value)
+	if err != nil {
+		return nil, err
+	}
+
+	switch cacheType(typ) {
+	case typeLocal:
+		return parseLocal(attrs)
+	case typeRegistry:
+		return parseRegistry(attrs)
+	case typeS3:
+		return parseS3(attrs)
+	case typeAzure:
+		return parseAzure(attrs)
+	case typeGHA:
+		return parseGHA(attrs)
+	case typeInline:
+		if err := attrs.Check(typ, "ref"); err != nil {
+			return nil, err
+		}
+
+		return &Inline{Ref: attrs.String("ref")}, nil
+	}
+
+	return nil, fmt.Errorf("%w %q", errUnknownType, typ)
+}
+
+func parseMode(attrs spec.Attrs) (Mode, error) {
+	switch mode := Mode(attrs.String("mode")); mode {
+	case "", ModeMin, ModeMax:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("%w %q (must be %s or %s)", errInvalidMode, mode, ModeMin, ModeMax)
+	}
+}
+
+func parseCompression(attrs spec.Attrs) (Compression, error) {
+	return ParseCompression(attrs.String("compression"))
+}
+
+// ParseCompression validates a compression name. Empty means default.
+func ParseCompression(value string) (Compression, error) {
+	switch compression := Compression(value); compression {
+	case "", CompressionUncompressed, CompressionGzip, CompressionEstargz, CompressionZstd:
+		return compression, nil
+	default:
+		return "", fmt.Errorf("%w %q", errCompression, compression)
+	}
+}
+
+func parseLocal(attrs spec.Attrs) (Entry, error) {
+	if err := attrs.Check(string(typeLocal), "src", "dest", "mode", "compression", "compression-level",
+		"force-compression", "oci-mediatypes", "ignore-error", "tag", "digest"); err != nil {
+		return nil, err
+	}
+
+	src, dest := attrs.String("src"), attrs.String("dest")
+	if src == "" && dest == "" || src != "" && dest != "" && src != dest {
+		return nil, errLocalPath
+	}
+
+	if src == "" {
+		src = dest
+	}
+
+	// Local exports always use OCI mediatypes
+	if attrs.String("oci-mediatypes") != "" {
+		if oci, err := attrs.Bool("oci-mediatypes"); err != nil || !oci {
+			return nil, fmt.Errorf("%w for oci-mediatypes: local cache requires OCI mediatypes", spec.ErrInvalidKey)
+		}
+	}
+
+	entry := &Local{
+		Path: src,
+		Tag:  attrs.String("tag"),
+	}
+
+	var err error
+
+	if entry.Mode, err = parseMode(attrs); err != nil {
+		return nil, err
+	}
+
+	if entry.Compression, err = parseCompression(attrs); err != nil {
+		return nil, err
+	}
+
+	if entry.CompressionLevel, err = attrs.Int("compression-level"); err != nil {
+		return nil, err
+	}
+
+	if err = entry.Compression.ValidateLevel(entry.CompressionLevel); err != nil {
+		return nil, err
+	}
+
+	if entry.ForceCompression, err = attrs.Bool("force-compression"); err != nil {
+		return nil, err
+	}
+
+	if entry.IgnoreError, err = attrs.Bool("ignore-error"); err != nil {
+		return nil, err
+	}
+
+	if dgst := attrs.String("digest"); dgst != "" {
+		entry.Digest = digest.Digest(dgst)
+		if err = entry.Digest.Validate(); err != nil {
+			return nil, fmt.Errorf("%w for digest: %s", spec.ErrInvalidKey, err.Error())
+		}
+	}
+
+	return entry, nil
+}
+
+func parseRegistry(attrs spec.Attrs) (Entry, error) {
+	if err := attrs.Check(string(typeRegistry),
+		"ref", "mode", "compression", "oci-mediatypes", "ignore-error", "image-manifest"); err != nil {
+		return nil, err
+	}
+
+	entry := &Registry{Ref: attrs.String("ref")}
+
+	var err error
+
+	if entry.Mode, err = parseMode(attrs); err != nil {
+		return nil, err
+	}
+
+	if entry.Compression, err = parseCompression(attrs); err != nil {
+		return nil, err
+	}
+
+	// Like buildkit, default to OCI mediatypes when not specified
+	if attrs.String("oci-mediatypes") != "" {
+		oci, err := attrs.Bool("oci-mediatypes")
+		if err != nil {
+			return nil, err
+		}
+
+		entry.DockerMediaTypes = !oci
+	}
+
+	if entry.IgnoreError, err = attrs.Bool("ignore-error"); err != nil {
+		return nil, err
+	}
+
+	if entry.ImageManifest, err = attrs.Bool("image-manifest"); err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+func parseS3(attrs spec.Attrs) (Entry, error) {
+	if err := attrs.Check(string(typeS3),
+		"bucket", "region", "endpoint_url", "prefix", "blobs_prefix", "manifests_prefix", "use_path_style",
+		"access_key_id", "secret_access_key", "session_token", "name", "mode", "ignore-error"); err != nil {
+		return nil, err
+	}
+
+	entry, err := NewS3(attrs.String("bucket"), attrs.String("region"), attrs.List("name")...)
+	if err != nil {
+		return nil, err
+	}
+
+	entry.EndpointURL = attrs.String("endpoint_url")
+	entry.Prefix = attrs.String("prefix")
+	entry.BlobsPrefix = attrs.String("blobs_prefix")
+	entry.ManifestsPrefix = attrs.String("manifests_prefix")
+	entry.AccessKeyID = attrs.String("access_key_id")
+	entry.SecretAccessKey = attrs.String("secret_access_key")
+	entry.SessionToken = attrs.String("session_token")
+
+	if entry.UsePathStyle, err = attrs.Bool("use_path_style"); err != nil {
+		return nil, err
+	}
+
+	if entry.Mode, err = parseMode(attrs); err != nil {
+		return nil, err
+	}
+
+	if entry.IgnoreError, err = attrs.Bool("ignore-error"); err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+func parseAzure(attrs spec.Attrs) (Entry, error) {
+	if err := attrs.Check(string(typeAzure),
+		"account_url", "container", "secret_access_key", "prefix", "blobs_prefix", "manifests_prefix", "name",
+		"mode", "ignore-error"); err != nil {
+		return nil, err
+	}
+
+	container := attrs.StringEnv("container", "BUILDKIT_AZURE_STORAGE_CONTAINER")
+	if container == "" {
+		container = "buildkit-cache"
+	}
+
+	entry, err := NewAzure(
+		attrs.StringEnv("account_url", "BUILDKIT_AZURE_STORAGE_ACCOUNT_URL"),
+		container,
+		attrs.String("secret_access_key"),
+		attrs.List("name")...,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	entry.Prefix = attrs.StringEnv("prefix", "BUILDKIT_AZURE_STORAGE_PREFIX")
+	entry.BlobsPrefix = attrs.String("blobs_prefix")
+	entry.ManifestsPrefix = attrs.String("manifests_prefix")
+
+	if entry.Mode, err = parseMode(attrs); err != nil {
+		return nil, err
+	}
+
+	if entry.IgnoreError, err = attrs.Bool("ignore-error"); err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+func parseGHA(attrs spec.Attrs) (Entry, error) {
+	if err := attrs.Check(string(typeGHA), "url", "token", "scope", "mode", "ignore-error"); err != nil {
+		return nil, err
+	}
+
+	ignoreError, err := attrs.Bool("ignore-error")
+	if err != nil {
+		return nil, err
+	}
+
+	entry, err := newGHA(
+		attrs.StringEnv("url", "ACTIONS_CACHE_URL"),
+		attrs.StringEnv("token", "ACTIONS_RUNTIME_TOKEN"),
+		attrs.String("scope"),
+		ignoreError,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if entry.Mode, err = parseMode(attrs); err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
@@ -0,0 +1,108 @@
+package cache_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"go.codecomet.dev/alkali/builder/cache"
+	"go.codecomet.dev/alkali/builder/spec"
+)
+
+func TestParseUnknownKeys(t *testing.T) {
+	t.Parallel()
+
+	for _, value := range []string{
+		"type=local,dest=cache,bogus=1",
+		"type=registry,ref=foo,bogus=1",
+		"type=s3,bucket=cache,region=eu-west-3,bogus=1",
+		"type=azblob,account_url=https://account.blob.core.windows.net,bogus=1",
+		"type=gha,url=http://localhost/,token=token,bogus=1",
+		"type=inline,bogus=1",
+	} {
+		if _, err := cache.Parse(value); !errors.Is(err, spec.ErrUnknownKey) {
+			t.Errorf("%s: expected an unknown key error, got %v", value, err)
+		}
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	t.Parallel()
+
+	for _, value := range []string{
+		"type=bogus",
+		"type=local",
+		"type=local,src=a,dest=b",
+		"type=local,dest=cache,oci-mediatypes=false",
+		"type=local,dest=cache,digest=sha256:nope",
+		"type=registry,ref=foo,mode=all",
+		"type=registry,ref=foo,compression=lz4",
+		"type=registry,ref=foo,ignore-error=maybe",
+	} {
+		if _, err := cache.Parse(value); err == nil {
+			t.Errorf("%s: expected an error", value)
+		}
+	}
+}
+
+// Entries formatted back parse into the same entry
+func TestParseRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	for _, value := range []string{
+		"type=local,dest=cache,tag=main,mode=min,compression=zstd,compression-level=3,ignore-error=true",
+		"type=registry,ref=localhost:5000/cache:main,mode=max,compression=estargz,image-manifest=true",
+		"type=registry,ref=localhost:5000/cache:main,oci-mediatypes=false",
+	} {
+		entry, err := cache.Parse(value)
+		if err != nil {
+			t.Errorf("%s: %v", value, err)
+
+			continue
+		}
+
+		reparsed, err := cache.Parse(entry.(interface{ String() string }).String())
+		if err != nil {
+			t.Errorf("%s: %v", value, err)
+
+			continue
+		}
+
+		if !reflect.DeepEqual(entry, reparsed) {
+			t.Errorf("%s: round trip gave %+v, expected %+v", value, reparsed, entry)
+		}
+	}
+}
+
+func TestParseAzureEnv(t *testing.T) {
+	t.Setenv("BUILDKIT_AZURE_STORAGE_ACCOUNT_URL", "https://account.blob.core.windows.net")
+	t.Setenv("BUILDKIT_AZURE_STORAGE_CONTAINER", "")
+	t.Setenv("BUILDKIT_AZURE_STORAGE_PREFIX", "ci/")
+
+	entry, err := cache.Parse("type=azblob,name=main")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	azure, ok := entry.(*cache.Azure)
+	if !ok {
+		t.Fatalf("unexpected entry %T", entry)
+	}
+
+	if azure.AccountURL != "https://account.blob.core.windows.net" || azure.Container != "buildkit-cache" ||
+		azure.Prefix != "ci/" {
+		t.Errorf("expected defaults from the environment, got %+v", azure)
+	}
+
+	// Attributes win over the environment
+	t.Setenv("BUILDKIT_AZURE_STORAGE_CONTAINER", "env")
+
+	entry, err = cache.Parse("type=azblob,container=attr,prefix=attr/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if azure = entry.(*cache.Azure); azure.Container != "attr" || azure.Prefix != "attr/" {
+		t.Errorf("expected attributes to win, got %+v", azure)
+	}
+}
@@ -0,0 +1,76 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/moby/buildkit/client"
+	"go.codecomet.dev/alkali/builder/spec"
+)
+
+var errRegistryRef = errors.New("cache with type registry requires a ref")
+
+// Registry stores cache in a registry, as a separate image (or manifest) pointed to by Ref.
+// Credentials are not handled here: the registry.Authenticator attached to the build session is used by the daemon.
+type Registry struct {
+	Ref         string
+	Mode        Mode
+	Compression Compression
+	// DockerMediaTypes uses docker mediatypes instead of OCI (the buildkit default)
+	DockerMediaTypes bool
+	IgnoreError      bool
+	// ImageManifest exports the cache as an image manifest instead of an index (requires buildkit 0.12+)
+	ImageManifest bool
+}
+
+func (o *Registry) String() string {
+	entry := o.ToExport()
+
+	return spec.Format(entry.Type, entry.Attrs)
+}
+
+func (o *Registry) ToImport() client.CacheOptionsEntry {
+	return client.CacheOptionsEntry{
+		Type: string(typeRegistry),
+		Attrs: map[string]string{
+			"ref": o.Ref,
+		},
+	}
+}
+
+func (o *Registry) ToExport() client.CacheOptionsEntry {
+	attrs := map[string]string{
+		"ref":            o.Ref,
+		"oci-mediatypes": fmt.Sprintf("%t", !o.DockerMediaTypes),
+		"ignore-error":   fmt.Sprintf("%t", o.IgnoreError),
+	}
+
+	if o.Mode != "" {
+		attrs["mode"] = string(o.Mode)
+	}
+
+	if o.Compression != "" {
+		attrs["compression"] = string(o.Compression)
+	}
+
+	if o.ImageManifest {
+		attrs["image-manifest"] = "true"
+	}
+
+	return client.CacheOptionsEntry{
+		Type:  string(typeRegistry),
+		Attrs: attrs,
+	}
+}
+
+func (o *Registry) Validate(daemonVersion string) error {
+	if o.Ref == "" {
+		return errRegistryRef
+	}
+
+	if o.ImageManifest {
+		return requireVersion(daemonVersion, "v0.12.0", "registry cache image-manifest")
+	}
+
+	return nil
+}
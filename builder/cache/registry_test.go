@@ -0,0 +1,68 @@
+package cache_test
+
+import (
+	"reflect"
+	"testing"
+
+	"go.codecomet.dev/alkali/builder/cache"
+)
+
+func TestRegistryDefaults(t *testing.T) {
+	t.Parallel()
+
+	built := &cache.Registry{Ref: "localhost:5000/cache:main"}
+
+	parsed, err := cache.Parse("type=registry,ref=localhost:5000/cache:main")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(built.ToExport(), parsed.ToExport()) {
+		t.Errorf("struct and parsed entries differ: %v, %v", built.ToExport(), parsed.ToExport())
+	}
+
+	if value := built.ToExport().Attrs["oci-mediatypes"]; value != "true" {
+		t.Errorf("expected OCI mediatypes by default, got %q", value)
+	}
+
+	parsed, err = cache.Parse("type=registry,ref=localhost:5000/cache:main,oci-mediatypes=false")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if value := parsed.ToExport().Attrs["oci-mediatypes"]; value != "false" {
+		t.Errorf("expected docker mediatypes, got %q", value)
+	}
+}
+
+func TestRegistryRequiresRef(t *testing.T) {
+	t.Parallel()
+
+	opts := &cache.Options{Export: []cache.Entry{&cache.Registry{Mode: cache.ModeMax}}}
+	if err := opts.Validate(""); err == nil {
+		t.Error("expected an error for a registry entry without ref")
+	}
+}
+
+func TestRegistryImageManifest(t *testing.T) {
+	t.Parallel()
+
+	entry := &cache.Registry{Ref: "localhost:5000/cache:main", ImageManifest: true}
+
+	for daemonVersion, valid := range map[string]bool{
+		"v0.11.6":      false,
+		"v0.12.0-rc1":  true,
+		"v0.12.1":      true,
+		"":             true,
+		"not-a-semver": true,
+	} {
+		if err := entry.Validate(daemonVersion); (err == nil) != valid {
+			t.Errorf("unexpected result for %q: %v", daemonVersion, err)
+		}
+	}
+
+	entry.ImageManifest = false
+	if err := entry.Validate("v0.11.6"); err != nil {
+		t.Errorf("expected index exports to work with older daemons: %v", err)
+	}
+}
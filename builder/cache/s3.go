@@ -0,0 +1,141 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/moby/buildkit/client"
+	"go.codecomet.dev/alkali/builder/spec"
+)
+
+var (
+	errS3Bucket = errors.New("cache with type s3 requires a bucket")
+	errS3Region = errors.New("cache with type s3 requires a region, or $AWS_REGION")
+)
+
+// S3 stores cache in an S3 compatible bucket (AWS, MinIO, etc).
+// Credentials and region that are not set explicitly are read from the usual AWS_* environment variables. If none
+// are found, the daemon will fall back to its own environment.
+type S3 struct {
+	Bucket          string
+	Region          string
+	EndpointURL     string
+	Prefix          string
+	BlobsPrefix     string
+	ManifestsPrefix string
+	UsePathStyle    bool
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	// Names of the cache manifests to import from, or export to. Defaults to "buildkit" on the daemon side.
+	Names       []string
+	Mode        Mode
+	IgnoreError bool
+}
+
+// NewS3 checks that the bucket and region are known (the region may come from the environment), rather than letting
+// the daemon fail on the first cache operation.
+func NewS3(bucket string, region string, names ...string) (*S3, error) {
+	entry := &S3{
+		Bucket: bucket,
+		Region: region,
+		Names:  names,
+	}
+
+	if err := entry.Validate(""); err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+func (o *S3) Validate(_ string) error {
+	if o.Bucket == "" {
+		return errS3Bucket
+	}
+
+	if envDefault(o.Region, "AWS_REGION") == "" {
+		return errS3Region
+	}
+
+	return nil
+}
+
+func (o *S3) attrs() map[string]string {
+	attrs := map[string]string{
+		"bucket": o.Bucket,
+		"region": o.Region,
+	}
+
+	if attrs["region"] == "" {
+		attrs["region"] = os.Getenv("AWS_REGION")
+	}
+
+	optional := map[string]string{
+		"endpoint_url":      o.EndpointURL,
+		"prefix":            o.Prefix,
+		"blobs_prefix":      o.BlobsPrefix,
+		"manifests_prefix":  o.ManifestsPrefix,
+		"access_key_id":     envDefault(o.AccessKeyID, "AWS_ACCESS_KEY_ID"),
+		"secret_access_key": envDefault(o.SecretAccessKey, "AWS_SECRET_ACCESS_KEY"),
+		"session_token":     envDefault(o.SessionToken, "AWS_SESSION_TOKEN"),
+		"name":              strings.Join(o.Names, ";"),
+	}
+
+	for k, v := range optional {
+		if v != "" {
+			attrs[k] = v
+		}
+	}
+
+	if o.UsePathStyle {
+		attrs["use_path_style"] = "true"
+	}
+
+	return attrs
+}
+
+// GetSecretValues includes credentials read from the environment, as they are sent to the daemon all the same.
+func (o *S3) GetSecretValues() []string {
+	return []string{
+		envDefault(o.SecretAccessKey, "AWS_SECRET_ACCESS_KEY"),
+		envDefault(o.SessionToken, "AWS_SESSION_TOKEN"),
+	}
+}
+
+func (o *S3) String() string {
+	entry := o.ToExport()
+
+	return spec.Format(entry.Type, entry.Attrs, "access_key_id", "secret_access_key", "session_token")
+}
+
+func (o *S3) ToImport() client.CacheOptionsEntry {
+	return client.CacheOptionsEntry{
+		Type:  string(typeS3),
+		Attrs: o.attrs(),
+	}
+}
+
+func (o *S3) ToExport() client.CacheOptionsEntry {
+	attrs := o.attrs()
+	attrs["ignore-error"] = fmt.Sprintf("%t", o.IgnoreError)
+
+	if o.Mode != "" {
+		attrs["mode"] = string(o.Mode)
+	}
+
+	return client.CacheOptionsEntry{
+		Type:  string(typeS3),
+		Attrs: attrs,
+	}
+}
+
+func envDefault(value string, env string) string {
+	if value != "" {
+		return value
+	}
+
+	return os.Getenv(env)
+}
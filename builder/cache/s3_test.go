@@ -0,0 +1,99 @@
+package cache_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"go.codecomet.dev/alkali/builder/cache"
+)
+
+func TestS3Credentials(t *testing.T) {
+	t.Setenv("AWS_REGION", "eu-west-3")
+	t.Setenv("AWS_ACCESS_KEY_ID", "minio")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "env-secret")
+	t.Setenv("AWS_SESSION_TOKEN", "")
+
+	entry, err := cache.Parse("type=s3,bucket=cache,endpoint_url=http://localhost:9000,use_path_style=true,name=a;b")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	attrs := entry.ToExport().Attrs
+
+	for key, want := range map[string]string{
+		"bucket":            "cache",
+		"region":            "eu-west-3",
+		"endpoint_url":      "http://localhost:9000",
+		"use_path_style":    "true",
+		"access_key_id":     "minio",
+		"secret_access_key": "env-secret",
+		"name":              "a;b",
+	} {
+		if attrs[key] != want {
+			t.Errorf("expected %s=%q, got %q", key, want, attrs[key])
+		}
+	}
+
+	if _, ok := attrs["session_token"]; ok {
+		t.Error("expected empty session token to be omitted")
+	}
+
+	if str := entry.(fmt.Stringer).String(); strings.Contains(str, "env-secret") {
+		t.Errorf("secret leaked in %s", str)
+	}
+
+	opts := &cache.Options{Import: []cache.Entry{entry}, Export: []cache.Entry{}}
+	if secrets := opts.GetSecretValues(); !contains(secrets, "env-secret") {
+		t.Errorf("expected the secret key to be redacted, got %v", secrets)
+	}
+}
+
+func TestS3Required(t *testing.T) {
+	t.Setenv("AWS_REGION", "")
+
+	for _, spec := range []string{
+		"type=s3,region=eu-west-3",
+		"type=s3,bucket=,region=eu-west-3",
+		"type=s3,bucket=cache",
+		"type=s3,bucket=cache,region=",
+	} {
+		if _, err := cache.Parse(spec); err == nil {
+			t.Errorf("expected %q to fail", spec)
+		}
+	}
+
+	if _, err := cache.NewS3("", "eu-west-3"); err == nil {
+		t.Error("expected an empty bucket to fail")
+	}
+
+	if _, err := cache.NewS3("cache", ""); err == nil {
+		t.Error("expected an empty region to fail")
+	}
+
+	if err := (&cache.S3{Bucket: "cache"}).Validate("v0.12.0"); err == nil {
+		t.Error("expected validation to fail without a region")
+	}
+
+	// The region may come from the environment
+	t.Setenv("AWS_REGION", "eu-west-3")
+
+	entry, err := cache.NewS3("cache", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = entry.Validate("v0.12.0"); err != nil {
+		t.Error(err)
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
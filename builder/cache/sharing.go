@@ -0,0 +1,195 @@
+package cache
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/moby/buildkit/client"
+	"go.codecomet.dev/core/log"
+)
+
+// DefaultKeyTemplate keys shared cache per project and branch.
+const DefaultKeyTemplate = "{{.Project}}-{{.Branch}}"
+
+// Tags are limited to 128 characters
+const maxKeyLength = 128
+
+var (
+	errSharingBackend = errors.New("shared cache backend must be registry or local")
+	errSharingKey     = errors.New("invalid shared cache key template")
+
+	invalidKeyChars = regexp.MustCompile(`[^a-zA-Z0-9_.-]+`) //nolint:gochecknoglobals
+)
+
+// Sharing automatically exports the cache of every run to a shared backend, and imports the most relevant keys (same
+// branch first, then default branch), so that builds landing on different nodes do not start cold.
+// With a registry backend, Target is a repository and keys are tags. With a local backend, Target is a directory shared
+// by all nodes and keys are tags in its index.
+// Use NewSharing to get one, as the backend can not be set otherwise.
+type Sharing struct {
+	Target        string
+	Project       string
+	Branch        string
+	DefaultBranch string
+	Mode          Mode
+
+	backend cacheType
+	tmpl    *template.Template
+}
+
+type sharingKey struct {
+	Project string
+	Branch  string
+}
+
+func NewSharing(backend string, target string, project string, branch string, defaultBranch string) (*Sharing, error) {
+	if err := validateSharingBackend(cacheType(backend)); err != nil {
+		return nil, err
+	}
+
+	shr := &Sharing{
+		Target:        target,
+		Project:       project,
+		Branch:        branch,
+		DefaultBranch: defaultBranch,
+		Mode:          ModeMax,
+		backend:       cacheType(backend),
+	}
+
+	return shr, shr.SetKeyTemplate(DefaultKeyTemplate)
+}
+
+// SetKeyTemplate changes how keys are derived. The template is rendered with .Project and .Branch.
+func (o *Sharing) SetKeyTemplate(tmpl string) error {
+	parsed, err := template.New("key").Option("missingkey=error").Parse(tmpl)
+	if err != nil {
+		return fmt.Errorf("%w: %s", errSharingKey, err.Error())
+	}
+
+	if _, err = o.render(parsed, o.Branch); err != nil {
+		return err
+	}
+
+	o.tmpl = parsed
+
+	return nil
+}
+
+// Key returns the key for a given branch. Without a template set, DefaultKeyTemplate is used.
+func (o *Sharing) Key(branch string) (string, error) {
+	tmpl := o.tmpl
+	if tmpl == nil {
+		tmpl = template.Must(template.New("key").Option("missingkey=error").Parse(DefaultKeyTemplate))
+	}
+
+	return o.render(tmpl, branch)
+}
+
+// Validate checks the backend, as sharings not obtained from NewSharing have none.
+func (o *Sharing) Validate() error {
+	return validateSharingBackend(o.backend)
+}
+
+func validateSharingBackend(backend cacheType) error {
+	if backend != typeRegistry && backend != typeLocal {
+		return fmt.Errorf("%w: %q (use NewSharing)", errSharingBackend, backend)
+	}
+
+	return nil
+}
+
+func (o *Sharing) render(tmpl *template.Template, branch string) (string, error) {
+	buf := new(bytes.Buffer)
+	if err := tmpl.Execute(buf, sharingKey{Project: o.Project, Branch: branch}); err != nil {
+		return "", fmt.Errorf("%w: %s", errSharingKey, err.Error())
+	}
+
+	key := strings.Trim(invalidKeyChars.ReplaceAllString(buf.String(), "-"), "-.")
+	if key == "" {
+		return "", fmt.Errorf("%w: renders to an empty key", errSharingKey)
+	}
+
+	if len(key) > maxKeyLength {
+		key = key[:maxKeyLength]
+	}
+
+	return key, nil
+}
+
+func (o *Sharing) keys() []string {
+	keys := []string{}
+
+	for _, branch := range []string{o.Branch, o.DefaultBranch} {
+		if branch == "" {
+			continue
+		}
+
+		key, err := o.Key(branch)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed computing shared cache key")
+
+			continue
+		}
+
+		if len(keys) == 0 || keys[0] != key {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys
+}
+
+func (o *Sharing) toImport() []client.CacheOptionsEntry {
+	ret := []client.CacheOptionsEntry{}
+
+	if o.Validate() != nil {
+		return ret
+	}
+
+	for _, key := range o.keys() {
+		if o.backend == typeRegistry {
+			ret = append(ret, (&Registry{Ref: o.Target + ":" + key}).ToImport())
+
+			continue
+		}
+
+		// Buildkit fails the whole build if the tag does not exist
+		if !NewLocalStore(o.Target).Has(key) {
+			continue
+		}
+
+		ret = append(ret, (&Local{Path: o.Target, Tag: key}).ToImport())
+	}
+
+	return ret
+}
+
+func (o *Sharing) toExport() []client.CacheOptionsEntry {
+	keys := o.keys()
+	if len(keys) == 0 || o.Validate() != nil {
+		return []client.CacheOptionsEntry{}
+	}
+
+	if o.backend == typeRegistry {
+		return []client.CacheOptionsEntry{
+			(&Registry{
+				Ref:         o.Target + ":" + keys[0],
+				Mode:        o.Mode,
+				IgnoreError: true,
+			}).ToExport(),
+		}
+	}
+
+	return []client.CacheOptionsEntry{
+		(&Local{
+			Path:        o.Target,
+			Tag:         keys[0],
+			Mode:        o.Mode,
+			IgnoreError: true,
+		}).ToExport(),
+	}
+}
@@ -0,0 +1,55 @@
+package cache_test
+
+import (
+	"testing"
+
+	"go.codecomet.dev/alkali/builder/cache"
+)
+
+func TestSharingLiteral(t *testing.T) {
+	t.Parallel()
+
+	shr := &cache.Sharing{Target: "registry.local/cache", Project: "app", Branch: "feature/x"}
+
+	key, err := shr.Key("feature/x")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if key != "app-feature-x" {
+		t.Errorf("expected the default template, got %q", key)
+	}
+
+	opts := &cache.Options{Import: []cache.Entry{}, Export: []cache.Entry{}, Sharing: shr}
+	if err = opts.Validate(""); err == nil {
+		t.Error("expected an error for a sharing without backend")
+	}
+
+	if len(opts.ToClientImport()) != 0 || len(opts.ToClientExport()) != 0 {
+		t.Error("expected a sharing without backend not to default to local")
+	}
+}
+
+func TestSharingRegistry(t *testing.T) {
+	t.Parallel()
+
+	shr, err := cache.NewSharing("registry", "registry.local/cache", "app", "feature", "main")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := &cache.Options{Import: []cache.Entry{}, Export: []cache.Entry{}, Sharing: shr}
+	if err = opts.Validate(""); err != nil {
+		t.Fatal(err)
+	}
+
+	imports := opts.ToClientImport()
+	if len(imports) != 2 || imports[0].Attrs["ref"] != "registry.local/cache:app-feature" ||
+		imports[1].Attrs["ref"] != "registry.local/cache:app-main" {
+		t.Errorf("unexpected imports %v", imports)
+	}
+
+	if _, err = cache.NewSharing("", "registry.local/cache", "app", "feature", "main"); err == nil {
+		t.Error("expected an error for an empty backend")
+	}
+}
@@ -0,0 +1,101 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var errUnsupported = errors.New("not supported by the builder")
+
+// Entries that are misconfigured, or use features only recent daemons understand. Older daemons silently ignore unknown
+// attributes, so this is checked before solving.
+type validator interface {
+	Validate(daemonVersion string) error
+}
+
+// Entries with requirements only relevant to imports
+type importValidator interface {
+	ValidateImport() error
+}
+
+// Validate checks every entry against the buildkit version of the daemon (eg: `v0.11.6`).
+// Versions that cannot be parsed (development builds) are assumed to support everything.
+func (o *Options) Validate(daemonVersion string) error {
+	if o.Sharing != nil {
+		if err := o.Sharing.Validate(); err != nil {
+			return err
+		}
+	}
+
+	for _, entry := range o.Import {
+		if val, ok := entry.(importValidator); ok && enabled(entry) {
+			if err := val.ValidateImport(); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, entry := range append(append([]Entry{}, o.Import...), o.Export...) {
+		if val, ok := entry.(validator); ok && enabled(entry) {
+			if err := val.Validate(daemonVersion); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+type version [3]int
+
+func parseVersion(value string) (version, bool) {
+	ver := version{}
+
+	value = strings.TrimPrefix(value, "v")
+	if idx := strings.IndexAny(value, "-+"); idx != -1 {
+		value = value[:idx]
+	}
+
+	parts := strings.Split(value, ".")
+	if len(parts) != len(ver) {
+		return ver, false
+	}
+
+	for idx, part := range parts {
+		num, err := strconv.Atoi(part)
+		if err != nil {
+			return ver, false
+		}
+
+		ver[idx] = num
+	}
+
+	return ver, true
+}
+
+func (o version) less(other version) bool {
+	for idx := range o {
+		if o[idx] != other[idx] {
+			return o[idx] < other[idx]
+		}
+	}
+
+	return false
+}
+
+// requireVersion errors if daemonVersion is known to be older than minimum
+func requireVersion(daemonVersion string, minimum string, feature string) error {
+	have, ok := parseVersion(daemonVersion)
+	if !ok {
+		return nil
+	}
+
+	want, _ := parseVersion(minimum)
+	if have.less(want) {
+		return fmt.Errorf("%w: %s requires buildkit %s (have %s)", errUnsupported, feature, minimum, daemonVersion)
+	}
+
+	return nil
+}
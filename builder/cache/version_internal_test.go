@@ -0,0 +1,47 @@
+package cache
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseVersion(t *testing.T) {
+	t.Parallel()
+
+	for value, expected := range map[string]struct {
+		version version
+		ok      bool
+	}{
+		"v0.11.6":      {version{0, 11, 6}, true},
+		"v0.12.0-rc1":  {version{0, 12, 0}, true},
+		"0.10.3+dirty": {version{0, 10, 3}, true},
+		"":             {version{}, false},
+		"v0.12":        {version{}, false},
+		"garbage":      {version{}, false},
+		"v1.x.0":       {version{}, false},
+	} {
+		ver, ok := parseVersion(value)
+		if ok != expected.ok || ok && ver != expected.version {
+			t.Errorf("%q: expected %v %t, got %v %t", value, expected.version, expected.ok, ver, ok)
+		}
+	}
+}
+
+func TestRequireVersion(t *testing.T) {
+	t.Parallel()
+
+	for daemonVersion, supported := range map[string]bool{
+		"v0.11.6":     false,
+		"v0.12.0-rc1": true,
+		"v0.12.0":     true,
+		"v1.0.0":      true,
+		// Development builds are assumed to support everything
+		"":        true,
+		"garbage": true,
+	} {
+		err := requireVersion(daemonVersion, "v0.12.0", "feature")
+		if supported && err != nil || !supported && !errors.Is(err, errUnsupported) {
+			t.Errorf("%q: unexpected result %v", daemonVersion, err)
+		}
+	}
+}
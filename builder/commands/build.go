@@ -9,17 +9,29 @@ import (
 	"os"
 	"strings"
 
+	"github.com/containerd/containerd/reference/docker"
 	"github.com/moby/buildkit/client"
 	"github.com/moby/buildkit/client/llb"
+	"github.com/moby/buildkit/exporter/containerimage/exptypes"
 	gateway "github.com/moby/buildkit/frontend/gateway/client"
 	"github.com/moby/buildkit/solver/pb"
 	"github.com/moby/buildkit/util/progress/progresswriter"
 	"go.codecomet.dev/alkali/builder/builder"
+	"go.codecomet.dev/alkali/builder/cache"
+	"go.codecomet.dev/alkali/builder/exporter"
+	"go.codecomet.dev/alkali/builder/logs"
+	"go.codecomet.dev/alkali/builder/stats"
+	"go.codecomet.dev/alkali/builder/trace"
+	"go.codecomet.dev/alkali/builder/visibility"
 	"go.codecomet.dev/containers/digest"
+	"go.codecomet.dev/core/log"
 	"golang.org/x/sync/errgroup"
 )
 
-var errEmptyDefinition = errors.New("empty definition sent to build")
+var (
+	errEmptyDefinition    = errors.New("empty definition sent to build")
+	errInlineWithoutImage = errors.New("inline cache export requires an image, docker or oci exporter")
+)
 
 func read(reader io.Reader, noCache bool) (*llb.Definition, error) {
 	// Read it
@@ -61,29 +73,129 @@ func read(reader io.Reader, noCache bool) (*llb.Definition, error) {
 	return &def, nil
 }
 
-func Run(ctx context.Context, buildOp *builder.Operation) (map[string]string, []*client.SolveStatus, error) { //nolint:gocognit
+type Result struct {
+	ExporterResponse map[string]string
+	// Exported is the typed version of ExporterResponse
+	Exported *exporter.Response
+	Traces   []*client.SolveStatus
+	// Warnings reported by buildkit during the run, deduplicated
+	Warnings []*trace.Warning
+	Cache    *stats.Stats
+}
+
+// Run builds and returns the exporter response and the status stream of the run.
+//
+// Deprecated: use Build, which returns the complete result (and warnings of failed runs).
+func Run(ctx context.Context, buildOp *builder.Operation) (map[string]string, []*client.SolveStatus, error) {
+	res, err := Build(ctx, buildOp)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return res.ExporterResponse, res.Traces, nil
+}
+
+// Build runs the operation. If the build itself fails, a partial result (traces and warnings) is returned along with
+// the error, as warnings usually explain failures. Failures after the export (eg: signing) return everything known
+// about the exported images as well.
+func Build(ctx context.Context, buildOp *builder.Operation) (*Result, error) { //nolint:gocognit
+	if err := exporter.Validate(buildOp.Export); err != nil {
+		return nil, err
+	}
+
+	if err := checkSignable(buildOp); err != nil {
+		return nil, err
+	}
+
+	if err := checkPlatforms(buildOp); err != nil {
+		return nil, err
+	}
+
+	if err := checkInline(buildOp); err != nil {
+		return nil, err
+	}
+
 	// Try and get a client
 	cli, err := getClient(ctx, buildOp.Node)
 	if err != nil {
-		return nil, nil, fmt.Errorf("builder node down: %w", err)
+		return nil, fmt.Errorf("builder node down: %w", err)
 	}
 
 	// Get exporters
 	exporters := []client.ExportEntry{}
+
 	for _, v := range buildOp.Export {
-		exporters = append(exporters, v.GetEntry())
+		entry := v.GetEntry()
+		if ctxEntry, ok := v.(exporter.ContextEntry); ok {
+			entry = ctxEntry.GetEntryContext(ctx)
+		}
+
+		exporters = append(exporters, entry)
+	}
+
+	// Older daemons silently ignore cache attributes they do not know about. If the version is unknown, only the
+	// entries themselves are checked.
+	daemonVersion := ""
+	if info, err := cli.Info(ctx); err == nil {
+		daemonVersion = info.BuildkitVersion.Version
+	}
+
+	if err = buildOp.Cache.Validate(daemonVersion); err != nil {
+		return nil, err
 	}
 
+	cacheExports := buildOp.Cache.ToClientExport()
+
 	// Get SSH and Secrets
 	attachable, _ := buildOp.Options.GetAttachable()
 	// Get credentials
 	auth := buildOp.Credentials.GetAttachable()
 	attachable = append(attachable, auth...)
 
+	// Anything secret must not make it to the display, traces, or logs
+	secrets := buildOp.Options.GetSecretValues()
+	secrets = append(secrets, buildOp.Credentials.GetSecretValues()...)
+	redactor := logs.NewRedactor(append(secrets, buildOp.Cache.GetSecretValues()...)...)
+
+	// Runs that were not created with run.New have no log store
+	if buildOp.Run.Logs == nil {
+		buildOp.Run.Logs = logs.NewStore("", logs.DefaultMemoryLimit)
+	}
+
+	// Read protobuf into a definition, or one per platform
+	def, platformDefs, err := readDefinitions(buildOp.Run, buildOp.Cache.NoCache)
+	if err != nil {
+		return nil, err
+	}
+
+	frontendAttrs, err := buildOp.Attestations.FrontendAttrs(buildOp.Run)
+	if err != nil {
+		return nil, err
+	}
+
+	var imageConfig []byte
+
+	if buildOp.ImageConfig != nil && def != nil {
+		config := buildOp.ImageConfig
+		if config.Platform == nil {
+			plt, err := definitionPlatform(def)
+			if err != nil {
+				return nil, err
+			}
+
+			config = config.ForPlatform(*plt)
+		}
+
+		if imageConfig, err = config.ToJSON(); err != nil {
+			return nil, err
+		}
+	}
+
 	// Create buildkit solve options
 	solveOpt := client.SolveOpt{
+		FrontendAttrs:       frontendAttrs,
 		Exports:             exporters,
-		CacheExports:        buildOp.Cache.ToClientExport(),
+		CacheExports:        cacheExports,
 		CacheImports:        buildOp.Cache.ToClientImport(),
 		Session:             attachable,
 		AllowedEntitlements: buildOp.Options.GetEntitlements(),
@@ -94,27 +206,18 @@ func Run(ctx context.Context, buildOp *builder.Operation) (map[string]string, []
 	// Get tracer buffer
 	traceEnc := json.NewEncoder(buildOp.Run.Trace)
 
-	// Get error group
-	errGroup, ctx := errgroup.WithContext(ctx)
-
-	// Read protobuf into a definition
-	var def *llb.Definition
-
-	def, err = read(buildOp.Run.Protobuf, buildOp.Cache.NoCache)
-	if err != nil {
-		return nil, nil, err
-	}
-
-	if len(def.Def) == 0 {
-		return nil, nil, errEmptyDefinition
-	}
+	// Get error group. Its context is cancelled once the solve is done, hence only used for solving.
+	errGroup, groupCtx := errgroup.WithContext(ctx)
 
 	// not using shared context to not disrupt display but let it finish reporting errors
-	progWriter, err := progresswriter.NewPrinter(context.TODO(), os.Stderr, buildOp.Progress) //nolint:contextcheck
+	printer, err := progresswriter.NewPrinter(context.TODO(), os.Stderr, buildOp.Progress) //nolint:contextcheck
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 
+	// Hidden vertexes only get out of the display - traces are kept complete
+	progWriter := visibility.Filter(printer, buildOp.Visibility)
+
 	traces := []*client.SolveStatus{}
 	if traceEnc != nil {
 		traceCh := make(chan *client.SolveStatus)
@@ -126,6 +229,10 @@ func Run(ctx context.Context, buildOp *builder.Operation) (map[string]string, []
 				if err := traceEnc.Encode(s); err != nil {
 					return err
 				}
+
+				if err := buildOp.Run.Logs.Add(s); err != nil {
+					return err
+				}
 			}
 
 			return nil
@@ -173,7 +280,7 @@ func Run(ctx context.Context, buildOp *builder.Operation) (map[string]string, []
 			sreq.Definition = def.ToPB()
 		}
 		resp, err := cli.Build(
-			ctx,
+			groupCtx,
 			solveOpt,
 			"codecomet-alkali",
 			func(ctx context.Context, gwClient gateway.Client) (*gateway.Result, error) {
@@ -184,7 +291,17 @@ func Run(ctx context.Context, buildOp *builder.Operation) (map[string]string, []
 					}
 				}
 
-				res, err := gwClient.Solve(ctx, sreq)
+				var (
+					res *gateway.Result
+					err error
+				)
+
+				if len(platformDefs) > 0 {
+					res, err = solvePlatforms(ctx, gwClient, sreq, platformDefs, buildOp.ImageConfig)
+				} else {
+					res, err = gwClient.Solve(ctx, sreq)
+				}
+
 				if err != nil {
 					return nil, err
 				}
@@ -193,8 +310,12 @@ func Run(ctx context.Context, buildOp *builder.Operation) (map[string]string, []
 					subMetadata = res.Metadata
 				}
 
+				if imageConfig != nil && res != nil {
+					res.AddMeta(exptypes.ExporterImageConfigKey, imageConfig)
+				}
+
 				return res, err
-			}, progresswriter.ResetTime(multiWriter.WithPrefix("", false)).Status())
+			}, progresswriter.ResetTime(logs.Redact(multiWriter.WithPrefix("", false), redactor)).Status())
 		if err != nil {
 			return err
 		}
@@ -217,8 +338,18 @@ func Run(ctx context.Context, buildOp *builder.Operation) (map[string]string, []
 		return progWriter.Err()
 	})
 
-	if err := errGroup.Wait(); err != nil {
-		return nil, nil, err
+	err = errGroup.Wait()
+
+	// The trace is ingested once, for warnings and cache statistics alike
+	trc := trace.Ingest(traces, buildOp.Visibility)
+	trace.PrintWarnings(os.Stderr, trc.Warnings)
+
+	if err != nil {
+		return &Result{Traces: traces, Warnings: trc.Warnings}, err
+	}
+
+	if err := buildOp.Cache.TouchLocal(); err != nil {
+		log.Error().Err(err).Msg("Failed marking local cache as used, it may be pruned early.")
 	}
 
 	if txt, ok := subMetadata["result.txt"]; ok {
@@ -231,5 +362,92 @@ func Run(ctx context.Context, buildOp *builder.Operation) (map[string]string, []
 		}
 	}
 
-	return exportResponse, traces, nil
+	// Images are exported (or pushed) by now: failures from here on return the result along with the error
+	result := &Result{ExporterResponse: exportResponse, Traces: traces, Warnings: trc.Warnings}
+
+	exported, err := exporter.ParseResponse(exportResponse)
+	if err != nil {
+		return result, err
+	}
+
+	result.Exported = exported
+
+	for _, v := range buildOp.Export {
+		switch entry := v.(type) {
+		case *exporter.Docker:
+			if entry.ImageID() != "" {
+				fmt.Fprintf(os.Stderr, "Loaded image %s %s\n", entry.ImageID(), strings.Join(entry.Loaded(), " "))
+			}
+		case *exporter.Local:
+			if !entry.OCI {
+				continue
+			}
+
+			if err = exported.WithOCILayout(entry.Path); err != nil {
+				log.Error().Err(err).Msg("Failed reading platforms from the exported OCI layout.")
+			}
+		case *exporter.Image:
+			if !entry.Push || len(entry.AllNames()) == 0 {
+				continue
+			}
+
+			named, err := docker.ParseNormalizedNamed(entry.AllNames()[0])
+			if err != nil {
+				return result, err
+			}
+
+			if err = exported.WithRegistry(ctx, buildOp.Credentials.Resolver(), named.Name()); err != nil {
+				log.Error().Err(err).Msg("Failed reading platforms from the pushed index.")
+			}
+		}
+	}
+
+	if err = signExports(ctx, buildOp, exported); err != nil {
+		return result, err
+	}
+
+	result.Cache = cacheStatistics(trc, traces, buildOp)
+	stats.Print(os.Stderr, result.Cache)
+
+	return result, nil
+}
+
+// checkInline fails runs exporting inline cache without an image to embed it in. Inline cache is embedded in the image
+// config, which docker and oci exporters write as well.
+func checkInline(buildOp *builder.Operation) error {
+	if !cache.HasInline(buildOp.Cache.ToClientExport()) {
+		return nil
+	}
+
+	for _, v := range buildOp.Export {
+		switch v.GetEntry().Type {
+		case client.ExporterImage, client.ExporterDocker, client.ExporterOCI:
+			return nil
+		}
+	}
+
+	return errInlineWithoutImage
+}
+
+// Failing to read or write the history only makes the time saved estimate less accurate, hence is not fatal
+func cacheStatistics(trc *trace.Trace, traces []*client.SolveStatus, buildOp *builder.Operation) *stats.Stats {
+	var history *stats.History
+
+	if buildOp.Cache.History != "" {
+		var err error
+
+		// Corrupt histories are returned empty, along with the error, and replaced once saved
+		history, err = stats.LoadHistory(buildOp.Cache.History)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed reading cache history. Time saved will not be estimated.")
+		}
+	}
+
+	cacheStats := stats.FromTrace(trc, traces, history)
+
+	if err := history.Save(); err != nil {
+		log.Error().Err(err).Msg("Failed writing cache history.")
+	}
+
+	return cacheStats
 }
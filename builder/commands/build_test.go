@@ -0,0 +1,41 @@
+package commands
+
+import (
+	"testing"
+
+	"go.codecomet.dev/alkali/builder/builder"
+	"go.codecomet.dev/alkali/builder/cache"
+	"go.codecomet.dev/alkali/builder/exporter"
+)
+
+func TestCheckInline(t *testing.T) {
+	t.Parallel()
+
+	for _, test := range []struct {
+		name  string
+		entry exporter.Entry
+		valid bool
+	}{
+		{"image", &exporter.Image{Names: []string{"app"}}, true},
+		{"docker", &exporter.Docker{Names: []string{"app"}}, true},
+		{"oci", &exporter.Local{Path: "app.tar", OCI: true}, true},
+		{"oci stream", &exporter.Stream{OCI: true}, true},
+		{"local", &exporter.Local{Path: "out"}, false},
+		{"tar", &exporter.Local{Path: "out.tar"}, false},
+	} {
+		buildOp := builder.NewOperation(t.TempDir())
+		buildOp.Cache.Export = []cache.Entry{&cache.Inline{}}
+		buildOp.Export = []exporter.Entry{test.entry}
+
+		if err := checkInline(buildOp); (err == nil) != test.valid {
+			t.Errorf("%s: unexpected result %v", test.name, err)
+		}
+	}
+
+	buildOp := builder.NewOperation(t.TempDir())
+	buildOp.Export = []exporter.Entry{&exporter.Local{Path: "out"}}
+
+	if err := checkInline(buildOp); err != nil {
+		t.Errorf("runs without inline cache need no image: %v", err)
+	}
+}
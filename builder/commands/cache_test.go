@@ -0,0 +1,233 @@
+package commands_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/moby/buildkit/client"
+	"github.com/moby/buildkit/client/llb"
+	"go.codecomet.dev/alkali/builder/builder"
+	"go.codecomet.dev/alkali/builder/cache"
+	"go.codecomet.dev/alkali/builder/commands"
+	"go.codecomet.dev/alkali/builder/internal/fakegha"
+	"go.codecomet.dev/alkali/builder/internal/fakeregistry"
+	"go.codecomet.dev/alkali/builder/trace"
+)
+
+// Cache tests need a buildkit daemon (eg: `tcp://localhost:1234`, or `unix:///run/buildkit/buildkitd.sock`).
+// Services started by the tests (registry, GHA cache) must be reachable from the daemon: if it runs in a container,
+// set the host address the daemon reaches this machine with (and declare it as an http registry in the daemon config,
+// as only localhost registries are reached over plain http by default).
+const (
+	envBuildkit = "ALKALI_TEST_BUILDKIT"
+	envHost     = "ALKALI_TEST_HOST"
+	envRegistry = "ALKALI_TEST_REGISTRY"
+	// An S3 compatible endpoint, reachable from the daemon (eg: MinIO), and an existing bucket. Credentials are read
+	// from the AWS_* environment variables.
+	envS3Endpoint = "ALKALI_TEST_S3_ENDPOINT"
+	envS3Bucket   = "ALKALI_TEST_S3_BUCKET"
+	// The blob endpoint of an Azurite emulator, reachable from the daemon (eg: `http://azurite:10000/devstoreaccount1`)
+	envAzureAccountURL = "ALKALI_TEST_AZURE_ACCOUNT_URL"
+	// Well-known key of the Azurite development account
+	azuriteKey = "Eby8vdM02xNOcqFlqUwJPLlmEtlCDXJ1OUzFT50uSRZ6IFsuFq2UVErCz4I6tq/K1SZFPTOtr/KBHBeksoGMGw=="
+)
+
+func buildkitAddress(t *testing.T) string {
+	t.Helper()
+
+	addr := os.Getenv(envBuildkit)
+	if addr == "" {
+		t.Skipf("%s is not set", envBuildkit)
+	}
+
+	return addr
+}
+
+// serviceHost returns the address the daemon reaches services started by the tests on, and the address to listen on
+func serviceHost(t *testing.T) (string, string) {
+	t.Helper()
+
+	if host := os.Getenv(envHost); host != "" {
+		return host, "0.0.0.0:0"
+	}
+
+	if addr := os.Getenv(envBuildkit); strings.HasPrefix(addr, "unix://") {
+		return "localhost", "127.0.0.1:0"
+	}
+
+	t.Skipf("%s is not set, and the daemon is not local", envHost)
+
+	return "", ""
+}
+
+// definition is a single file of random content, so that it is never cached by a previous run
+func definition(t *testing.T) *bytes.Buffer {
+	t.Helper()
+
+	data := make([]byte, 64) //nolint:gomnd
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+
+	def, err := llb.Scratch().File(llb.Mkfile("/data", 0o644, data)).Marshal(context.Background()) //nolint:gomnd
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := &bytes.Buffer{}
+	if err = llb.WriteTo(def, buf); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf
+}
+
+func build(t *testing.T, addr string, def *bytes.Buffer, opts *cache.Options) *commands.Result {
+	t.Helper()
+
+	operation := builder.NewOperation(addr)
+	operation.Progress = "plain"
+	operation.Cache = opts
+	operation.Ingest(bytes.NewBuffer(def.Bytes()), nil)
+
+	t.Cleanup(func() {
+		_ = operation.Run.Close()
+	})
+
+	res, err := commands.Build(context.Background(), operation)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return res
+}
+
+func prune(t *testing.T, addr string) {
+	t.Helper()
+
+	ctx := context.Background()
+
+	cli, err := client.New(ctx, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer cli.Close()
+
+	if err = cli.Prune(ctx, nil, client.PruneAll); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// roundTrip exports the cache of a build to entry, prunes the daemon, and checks the same build is cached once
+// imported from entry
+func roundTrip(t *testing.T, entry cache.Entry) {
+	t.Helper()
+
+	addr := buildkitAddress(t)
+	def := definition(t)
+
+	build(t, addr, def, &cache.Options{Export: []cache.Entry{entry}, Import: []cache.Entry{}})
+	prune(t, addr)
+
+	res := build(t, addr, def, &cache.Options{Export: []cache.Entry{}, Import: []cache.Entry{entry}})
+
+	for _, action := range trace.Ingest(res.Traces, nil).Actions {
+		if action.Cached {
+			return
+		}
+	}
+
+	t.Error("expected the build to be cached once imported")
+}
+
+func TestRegistryCache(t *testing.T) { //nolint:paralleltest
+	ref := os.Getenv(envRegistry)
+
+	if ref == "" {
+		buildkitAddress(t)
+		host, listen := serviceHost(t)
+
+		reg, err := fakeregistry.Listen(listen)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer reg.Close()
+
+		ref = net.JoinHostPort(host, strconv.Itoa(reg.Port())) + "/alkali/cache:test"
+	}
+
+	roundTrip(t, &cache.Registry{Ref: ref, Mode: cache.ModeMax})
+}
+
+func TestS3Cache(t *testing.T) { //nolint:paralleltest
+	endpoint := os.Getenv(envS3Endpoint)
+	bucket := os.Getenv(envS3Bucket)
+
+	if endpoint == "" || bucket == "" {
+		t.Skipf("%s and %s are not set", envS3Endpoint, envS3Bucket)
+	}
+
+	region := os.Getenv("AWS_REGION")
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	roundTrip(t, &cache.S3{
+		Bucket:       bucket,
+		Region:       region,
+		EndpointURL:  endpoint,
+		UsePathStyle: true,
+		Prefix:       t.Name() + "/",
+		Mode:         cache.ModeMax,
+	})
+}
+
+func TestAzureCache(t *testing.T) { //nolint:paralleltest
+	accountURL := os.Getenv(envAzureAccountURL)
+	if accountURL == "" {
+		t.Skipf("%s is not set", envAzureAccountURL)
+	}
+
+	entry, err := cache.NewAzure(accountURL, "alkali-test", azuriteKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entry.Prefix = t.Name() + "/"
+	entry.Mode = cache.ModeMax
+
+	roundTrip(t, entry)
+}
+
+func TestGHACache(t *testing.T) { //nolint:paralleltest
+	buildkitAddress(t)
+	host, listen := serviceHost(t)
+
+	service, err := fakegha.Listen(listen)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer service.Close()
+
+	entry := &cache.GHA{
+		URL:   service.URL(host),
+		Token: fakegha.Token("refs/heads/main"),
+		Scope: t.Name(),
+		Mode:  cache.ModeMax,
+	}
+
+	roundTrip(t, entry)
+
+	if len(service.Keys()) == 0 {
+		t.Error("expected cache entries to be saved")
+	}
+}
@@ -0,0 +1,206 @@
+package commands
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/containerd/containerd/platforms"
+	"github.com/moby/buildkit/client/llb"
+	"github.com/moby/buildkit/exporter/containerimage/exptypes"
+	gateway "github.com/moby/buildkit/frontend/gateway/client"
+	"github.com/moby/buildkit/solver/pb"
+	ocispecs "github.com/opencontainers/image-spec/specs-go/v1"
+	"go.codecomet.dev/alkali/builder/builder"
+	"go.codecomet.dev/alkali/builder/exporter"
+	"go.codecomet.dev/alkali/builder/image"
+	"go.codecomet.dev/alkali/builder/run"
+	"go.codecomet.dev/containers/digest"
+	"golang.org/x/sync/errgroup"
+)
+
+var (
+	errDuplicatePlatform = errors.New("platform defined more than once")
+	errUnknownPlatform   = errors.New("could not tell the platform of the definition, set it in the image config")
+	errDockerPlatforms   = errors.New("docker cannot load multi-platform images, use an image or oci exporter")
+)
+
+// checkPlatforms fails multi-platform runs with exporters that cannot handle them, before anything gets built.
+func checkPlatforms(buildOp *builder.Operation) error {
+	if len(buildOp.Run.Platforms) == 0 {
+		return nil
+	}
+
+	for _, v := range buildOp.Export {
+		if entry, ok := v.(*exporter.Docker); ok {
+			return fmt.Errorf("%w: %s", errDockerPlatforms, entry)
+		}
+	}
+
+	return nil
+}
+
+type platformDefinition struct {
+	platform *run.Platform
+	def      *llb.Definition
+}
+
+// readDefinitions reads the definition of the run, or the definition of each of its platforms. Buffers are not
+// consumed, as they are needed later on for reports.
+func readDefinitions(data *run.Data, noCache bool) (*llb.Definition, []*platformDefinition, error) {
+	if len(data.Platforms) == 0 {
+		if data.Protobuf == nil {
+			return nil, nil, errEmptyDefinition
+		}
+
+		def, err := read(bytes.NewReader(data.Protobuf.Bytes()), noCache)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if len(def.Def) == 0 {
+			return nil, nil, errEmptyDefinition
+		}
+
+		return def, nil, nil
+	}
+
+	defs := []*platformDefinition{}
+	seen := map[string]bool{}
+
+	for _, plt := range data.Platforms {
+		id := plt.ID()
+		if seen[id] {
+			return nil, nil, fmt.Errorf("%w: %s", errDuplicatePlatform, id)
+		}
+
+		seen[id] = true
+
+		if plt.Protobuf == nil {
+			return nil, nil, fmt.Errorf("%w for %s", errEmptyDefinition, id)
+		}
+
+		def, err := read(bytes.NewReader(plt.Protobuf.Bytes()), noCache)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed reading definition for %s: %w", id, err)
+		}
+
+		if len(def.Def) == 0 {
+			return nil, nil, fmt.Errorf("%w for %s", errEmptyDefinition, id)
+		}
+
+		defs = append(defs, &platformDefinition{platform: plt, def: def})
+	}
+
+	return nil, defs, nil
+}
+
+// definitionPlatform returns the platform of the result of def: the platform of the op its terminal op points to.
+func definitionPlatform(def *llb.Definition) (*ocispecs.Platform, error) {
+	ops := map[digest.Digest]*pb.Op{}
+
+	var terminal *pb.Op
+
+	for _, dt := range def.Def {
+		var op pb.Op
+		if err := (&op).Unmarshal(dt); err != nil {
+			return nil, fmt.Errorf("failed to parse llb proto op %w", err)
+		}
+
+		ops[digest.FromBytes(dt)] = &op
+		terminal = &op
+	}
+
+	if terminal == nil || len(terminal.Inputs) == 0 {
+		return nil, errUnknownPlatform
+	}
+
+	result, ok := ops[terminal.Inputs[0].Digest]
+	if !ok || result.Platform == nil {
+		return nil, errUnknownPlatform
+	}
+
+	return &ocispecs.Platform{
+		OS:           result.Platform.OS,
+		Architecture: result.Platform.Architecture,
+		Variant:      result.Platform.Variant,
+		OSVersion:    result.Platform.OSVersion,
+		OSFeatures:   result.Platform.OSFeatures,
+	}, nil
+}
+
+// solvePlatforms solves the definition of every platform concurrently, and returns a multi-platform result (one ref
+// per platform, described by the `refs.platforms` metadata) that image exporters turn into an index. Metadata of each
+// platform result (eg: subrequest results) is kept, suffixed with the platform ID.
+func solvePlatforms(
+	ctx context.Context,
+	gwClient gateway.Client,
+	sreq gateway.SolveRequest,
+	defs []*platformDefinition,
+	config *image.Config,
+) (*gateway.Result, error) {
+	refs := make([]gateway.Reference, len(defs))
+	metadata := make([]map[string][]byte, len(defs))
+	errGroup, ctx := errgroup.WithContext(ctx)
+
+	for idx, def := range defs {
+		idx, def := idx, def
+
+		errGroup.Go(func() error {
+			req := sreq
+			req.Definition = def.def.ToPB()
+
+			res, err := gwClient.Solve(ctx, req)
+			if err != nil {
+				return fmt.Errorf("failed solving %s: %w", def.platform.ID(), err)
+			}
+
+			metadata[idx] = res.Metadata
+			refs[idx], err = res.SingleRef()
+
+			return err
+		})
+	}
+
+	if err := errGroup.Wait(); err != nil {
+		return nil, err
+	}
+
+	res := gateway.NewResult()
+	expPlatforms := exptypes.Platforms{Platforms: []exptypes.Platform{}}
+
+	for idx, def := range defs {
+		id := def.platform.ID()
+
+		res.AddRef(id, refs[idx])
+
+		for k, v := range metadata[idx] {
+			res.AddMeta(k+"/"+id, v)
+		}
+
+		expPlatforms.Platforms = append(expPlatforms.Platforms, exptypes.Platform{
+			ID:       id,
+			Platform: platforms.Normalize(def.platform.Platform),
+		})
+
+		if config != nil {
+			byt, err := config.ForPlatform(def.platform.Platform).ToJSON()
+			if err != nil {
+				return nil, err
+			}
+
+			res.AddMeta(exptypes.ExporterImageConfigKey+"/"+id, byt)
+		}
+	}
+
+	byt, err := json.Marshal(expPlatforms)
+	if err != nil {
+		return nil, err
+	}
+
+	res.AddMeta(exptypes.ExporterPlatformsKey, byt)
+
+	return res, nil
+}
@@ -0,0 +1,58 @@
+package commands
+
+import (
+	"context"
+	"testing"
+
+	"github.com/moby/buildkit/client/llb"
+	ocispecs "github.com/opencontainers/image-spec/specs-go/v1"
+	"go.codecomet.dev/alkali/builder/builder"
+	"go.codecomet.dev/alkali/builder/exporter"
+)
+
+func TestDefinitionPlatform(t *testing.T) {
+	t.Parallel()
+
+	arm64 := ocispecs.Platform{OS: "linux", Architecture: "arm64"}
+
+	def, err := llb.Image("alpine:3.18", llb.Platform(arm64)).Marshal(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plt, err := definitionPlatform(def)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if plt.OS != arm64.OS || plt.Architecture != arm64.Architecture {
+		t.Errorf("unexpected platform %+v", plt)
+	}
+
+	if _, err = definitionPlatform(&llb.Definition{}); err == nil {
+		t.Error("expected an empty definition to have no platform")
+	}
+}
+
+func TestCheckPlatforms(t *testing.T) {
+	t.Parallel()
+
+	buildOp := builder.NewOperation(t.TempDir())
+	buildOp.Export = []exporter.Entry{&exporter.Docker{Names: []string{"app"}}}
+
+	if err := checkPlatforms(buildOp); err != nil {
+		t.Fatalf("single platform runs can be loaded: %v", err)
+	}
+
+	buildOp.IngestPlatform(ocispecs.Platform{OS: "linux", Architecture: "amd64"}, nil)
+
+	if err := checkPlatforms(buildOp); err == nil {
+		t.Error("expected docker exports of multi-platform runs to be refused")
+	}
+
+	buildOp.Export = []exporter.Entry{&exporter.Image{Names: []string{"app"}}}
+
+	if err := checkPlatforms(buildOp); err != nil {
+		t.Errorf("multi-platform runs can be exported as images: %v", err)
+	}
+}
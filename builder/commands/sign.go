@@ -0,0 +1,86 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/containerd/containerd/reference/docker"
+	"go.codecomet.dev/alkali/builder/builder"
+	"go.codecomet.dev/alkali/builder/exporter"
+	"go.codecomet.dev/alkali/builder/signing"
+)
+
+var errUnsignable = errors.New("signing is not supported for this export")
+
+// checkSignable fails runs with a signer exporting images that cannot be signed (images left in the builder, docker
+// loads and OCI tarballs), rather than leaving them silently unsigned.
+func checkSignable(buildOp *builder.Operation) error {
+	if buildOp.Signer == nil {
+		return nil
+	}
+
+	for _, v := range buildOp.Export {
+		switch entry := v.(type) {
+		case *exporter.Image:
+			if !entry.Push {
+				return fmt.Errorf("%w: %s (push the image, or export an OCI layout directory)", errUnsignable, entry)
+			}
+		case *exporter.Docker:
+			return fmt.Errorf("%w: %s (push the image, or export an OCI layout directory)", errUnsignable, entry)
+		case *exporter.Local:
+			if entry.OCI && entry.IsTar() {
+				return fmt.Errorf("%w: %s (export an OCI layout directory instead)", errUnsignable, entry)
+			}
+		case *exporter.Stream:
+			if entry.OCI {
+				return fmt.Errorf("%w: %s (export an OCI layout directory instead)", errUnsignable, entry)
+			}
+		}
+	}
+
+	return nil
+}
+
+// signExports signs whatever was pushed or exported as an OCI layout directory. Failing to sign fails the run, as
+// unsigned images would be refused downstream anyway.
+func signExports(ctx context.Context, buildOp *builder.Operation, exported *exporter.Response) error {
+	if buildOp.Signer == nil || exported.Descriptor == nil {
+		return nil
+	}
+
+	stores := []signing.Store{}
+
+	for _, v := range buildOp.Export {
+		switch entry := v.(type) {
+		case *exporter.Image:
+			if !entry.Push {
+				continue
+			}
+
+			for _, name := range entry.AllNames() {
+				named, err := docker.ParseNormalizedNamed(name)
+				if err != nil {
+					return err
+				}
+
+				stores = append(stores, &signing.Registry{
+					Name:     named.Name(),
+					Resolver: buildOp.Credentials.Resolver(),
+				})
+			}
+		case *exporter.Local:
+			if entry.OCI && !entry.IsTar() {
+				stores = append(stores, &signing.OCILayout{Path: entry.Path})
+			}
+		}
+	}
+
+	for _, store := range stores {
+		if _, err := signing.Sign(ctx, store, *exported.Descriptor, buildOp.Signer); err != nil {
+			return fmt.Errorf("failed signing %s: %w", exported.Descriptor.Digest, err)
+		}
+	}
+
+	return nil
+}
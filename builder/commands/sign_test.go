@@ -0,0 +1,40 @@
+package commands_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"strings"
+	"testing"
+
+	"go.codecomet.dev/alkali/builder/builder"
+	"go.codecomet.dev/alkali/builder/commands"
+	"go.codecomet.dev/alkali/builder/exporter"
+)
+
+func TestBuildRefusesUnsignableExports(t *testing.T) {
+	t.Parallel()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, entry := range []exporter.Entry{
+		&exporter.Image{Names: []string{"app"}},
+		&exporter.Docker{Names: []string{"app"}},
+		&exporter.Local{Path: "app.tar", OCI: true},
+		&exporter.Stream{OCI: true},
+	} {
+		buildOp := builder.NewOperation(t.TempDir())
+		buildOp.Signer = key
+		buildOp.Export = []exporter.Entry{entry}
+
+		// Refused before reaching any builder node
+		_, err := commands.Build(context.Background(), buildOp)
+		if err == nil || !strings.Contains(err.Error(), "signing is not supported") {
+			t.Errorf("expected %T to be refused when signing, got %v", entry, err)
+		}
+	}
+}
@@ -0,0 +1,272 @@
+package exporter
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/moby/buildkit/client"
+	"github.com/moby/buildkit/exporter/containerimage/exptypes"
+	"go.codecomet.dev/alkali/builder/spec"
+)
+
+const (
+	defaultDockerHost = "unix:///var/run/docker.sock"
+	dockerHostEnv     = "DOCKER_HOST"
+	dockerCLI         = "docker"
+
+	loadedImagePrefix   = "Loaded image: "
+	loadedImageIDPrefix = "Loaded image ID: "
+)
+
+var (
+	errDockerHost = errors.New("unsupported docker host")
+	errDockerLoad = errors.New("docker load failed")
+)
+
+// Docker loads the result into a docker daemon, as if `docker load` was fed the tarball produced by buildkit.
+// The daemon is reached through its API (Host, or DOCKER_HOST, or the default socket), or through the docker CLI if
+// CLI is set (useful for ssh hosts or contexts, which are not supported otherwise).
+type Docker struct {
+	Names []string
+	Host  string
+	CLI   bool
+
+	mu      sync.Mutex
+	imageID string
+	// configDigest is the image ID, should the daemon not report it
+	configDigest string
+	loaded       []string
+}
+
+func (o *Docker) String() string {
+	return spec.Format(string(typeDocker), map[string]string{"name": strings.Join(o.Names, ",")})
+}
+
+func (o *Docker) GetEntry() client.ExportEntry {
+	return o.GetEntryContext(context.Background())
+}
+
+// GetEntryContext is GetEntry, with the load cancelled along with ctx.
+func (o *Docker) GetEntryContext(ctx context.Context) client.ExportEntry {
+	return client.ExportEntry{
+		Type: string(typeDocker),
+		Attrs: map[string]string{
+			"name": strings.Join(o.Names, ","),
+		},
+		// Buildkit passes the exporter response along
+		Output: func(resp map[string]string) (io.WriteCloser, error) {
+			o.reset(resp[exptypes.ExporterImageConfigDigestKey])
+
+			return o.load(ctx)
+		},
+	}
+}
+
+// ImageID returns the ID of the loaded image, once the build is done. Daemons only report the ID of untagged images
+// (or only their names on some versions): it otherwise is the digest of the config, as reported by buildkit.
+func (o *Docker) ImageID() string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.imageID == "" {
+		return o.configDigest
+	}
+
+	return o.imageID
+}
+
+// Loaded returns the names reported by the daemon.
+func (o *Docker) Loaded() []string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return append([]string{}, o.loaded...)
+}
+
+// reset forgets about the previous load, if the exporter is reused
+func (o *Docker) reset(configDigest string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.imageID = ""
+	o.configDigest = configDigest
+	o.loaded = nil
+}
+
+func (o *Docker) record(line string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	line = strings.TrimSpace(line)
+
+	switch {
+	case strings.HasPrefix(line, loadedImageIDPrefix):
+		o.imageID = strings.TrimPrefix(line, loadedImageIDPrefix)
+	case strings.HasPrefix(line, loadedImagePrefix):
+		o.loaded = append(o.loaded, strings.TrimPrefix(line, loadedImagePrefix))
+	}
+}
+
+// loadWriter feeds the tarball to a load running in the background. Close waits for it to complete.
+type loadWriter struct {
+	*io.PipeWriter
+	done chan error
+}
+
+func (o *loadWriter) Close() error {
+	if err := o.PipeWriter.Close(); err != nil {
+		return err
+	}
+
+	return <-o.done
+}
+
+func (o *Docker) load(ctx context.Context) (io.WriteCloser, error) {
+	var run func(io.Reader) error
+
+	if o.CLI {
+		run = func(body io.Reader) error {
+			return o.loadCLI(ctx, body)
+		}
+	} else {
+		httpClient, base, err := o.apiClient()
+		if err != nil {
+			return nil, err
+		}
+
+		run = func(body io.Reader) error {
+			return o.loadAPI(ctx, httpClient, base, body)
+		}
+	}
+
+	reader, writer := io.Pipe()
+	done := make(chan error, 1)
+
+	go func() {
+		err := run(reader)
+		// Unblock buildkit if the load stopped consuming
+		_ = reader.CloseWithError(err)
+		done <- err
+	}()
+
+	return &loadWriter{PipeWriter: writer, done: done}, nil
+}
+
+func (o *Docker) host() string {
+	if o.Host != "" {
+		return o.Host
+	}
+
+	if host := os.Getenv(dockerHostEnv); host != "" {
+		return host
+	}
+
+	return defaultDockerHost
+}
+
+func (o *Docker) apiClient() (*http.Client, string, error) {
+	host, err := url.Parse(o.host())
+	if err != nil {
+		return nil, "", fmt.Errorf("%w %q: %s", errDockerHost, o.host(), err.Error())
+	}
+
+	switch host.Scheme {
+	case "unix":
+		socket := host.Path
+		dialer := &net.Dialer{}
+
+		return &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _ string, _ string) (net.Conn, error) {
+					return dialer.DialContext(ctx, "unix", socket)
+				},
+			},
+		}, "http://docker", nil
+	case "tcp", "http":
+		return &http.Client{}, "http://" + host.Host, nil
+	}
+
+	return nil, "", fmt.Errorf("%w %q (use the docker CLI instead)", errDockerHost, o.host())
+}
+
+func (o *Docker) loadAPI(ctx context.Context, httpClient *http.Client, base string, body io.Reader) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, base+"/images/load?quiet=1", body)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/x-tar")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %s", errDockerLoad, err.Error())
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+
+		return fmt.Errorf("%w: %s: %s", errDockerLoad, resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	// The daemon answers with a stream of json messages
+	decoder := json.NewDecoder(resp.Body)
+
+	for {
+		var msg struct {
+			Stream string `json:"stream"`
+			Error  string `json:"error"`
+		}
+
+		if err = decoder.Decode(&msg); errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("%w: %s", errDockerLoad, err.Error())
+		}
+
+		if msg.Error != "" {
+			return fmt.Errorf("%w: %s", errDockerLoad, msg.Error)
+		}
+
+		o.record(msg.Stream)
+	}
+}
+
+func (o *Docker) loadCLI(ctx context.Context, body io.Reader) error {
+	args := []string{"load"}
+	if o.Host != "" {
+		args = append([]string{"--host", o.Host}, args...)
+	}
+
+	stdout := new(bytes.Buffer)
+	stderr := new(bytes.Buffer)
+
+	cmd := exec.CommandContext(ctx, dockerCLI, args...)
+	cmd.Stdin = body
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%w: %s: %s", errDockerLoad, err.Error(), strings.TrimSpace(stderr.String()))
+	}
+
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		o.record(scanner.Text())
+	}
+
+	return nil
+}
@@ -0,0 +1,153 @@
+package exporter_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/moby/buildkit/exporter/containerimage/exptypes"
+	"go.codecomet.dev/alkali/builder/exporter"
+)
+
+// fakeDocker serves the image load API on a unix socket, answering with the given stream messages
+func fakeDocker(t *testing.T, messages ...string) (string, chan []byte) {
+	t.Helper()
+
+	// Socket paths are limited in length, and test temp dirs can be long
+	dir, err := os.MkdirTemp("", "docker")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		_ = os.RemoveAll(dir)
+	})
+
+	socket := filepath.Join(dir, "docker.sock")
+
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	received := make(chan []byte, 1)
+	server := &http.Server{ReadHeaderTimeout: time.Second, Handler: http.HandlerFunc(
+		func(writer http.ResponseWriter, req *http.Request) {
+			if req.Method != http.MethodPost || req.URL.Path != "/images/load" {
+				http.NotFound(writer, req)
+
+				return
+			}
+
+			body, _ := io.ReadAll(req.Body)
+			received <- body
+
+			encoder := json.NewEncoder(writer)
+			for _, msg := range messages {
+				_ = encoder.Encode(map[string]string{"stream": msg + "\n"})
+			}
+		},
+	)}
+
+	go func() {
+		_ = server.Serve(listener)
+	}()
+
+	t.Cleanup(func() {
+		_ = server.Close()
+	})
+
+	return "unix://" + socket, received
+}
+
+func export(t *testing.T, ctx context.Context, docker *exporter.Docker, resp map[string]string) error {
+	t.Helper()
+
+	writer, err := docker.GetEntryContext(ctx).Output(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = writer.Write([]byte("tarball")); err != nil {
+		return err
+	}
+
+	return writer.Close()
+}
+
+func TestDockerLoad(t *testing.T) {
+	t.Parallel()
+
+	host, received := fakeDocker(t, "Loaded image: app:1.0", "Loaded image: app:latest")
+	docker := &exporter.Docker{Names: []string{"app:1.0", "app:latest"}, Host: host}
+
+	configDigest := "sha256:4e5a1b0e8a0a1b6b3a0d7c2f0e1b5c7d9e2f4a6b8c0d2e4f6a8b0c2d4e6f8a0b"
+	if err := export(t, context.Background(), docker, map[string]string{
+		exptypes.ExporterImageConfigDigestKey: configDigest,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if body := <-received; string(body) != "tarball" {
+		t.Errorf("unexpected tarball %q", body)
+	}
+
+	// Tagged images are only reported by name: the ID is the config digest
+	if docker.ImageID() != configDigest {
+		t.Errorf("expected image ID %s, got %q", configDigest, docker.ImageID())
+	}
+
+	if loaded := docker.Loaded(); len(loaded) != 2 || loaded[0] != "app:1.0" {
+		t.Errorf("unexpected loaded names %v", loaded)
+	}
+
+	// Reusing the exporter does not accumulate results
+	if err := export(t, context.Background(), docker, map[string]string{}); err != nil {
+		t.Fatal(err)
+	}
+
+	<-received
+
+	if loaded := docker.Loaded(); len(loaded) != 2 || docker.ImageID() != "" {
+		t.Errorf("expected results of the previous load to be reset, got %v %q", loaded, docker.ImageID())
+	}
+}
+
+func TestDockerLoadID(t *testing.T) {
+	t.Parallel()
+
+	host, received := fakeDocker(t, "Loaded image ID: sha256:abc")
+	docker := &exporter.Docker{Host: host}
+
+	if err := export(t, context.Background(), docker, map[string]string{
+		exptypes.ExporterImageConfigDigestKey: "sha256:def",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	<-received
+
+	if docker.ImageID() != "sha256:abc" {
+		t.Errorf("expected the reported image ID, got %q", docker.ImageID())
+	}
+}
+
+func TestDockerLoadCancelled(t *testing.T) {
+	t.Parallel()
+
+	host, _ := fakeDocker(t)
+	docker := &exporter.Docker{Host: host}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := export(t, ctx, docker, map[string]string{}); err == nil {
+		t.Error("expected the load to stop with the build context")
+	}
+}
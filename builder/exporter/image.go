@@ -0,0 +1,211 @@
+package exporter
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/moby/buildkit/client"
+	"go.codecomet.dev/alkali/builder/cache"
+	"go.codecomet.dev/alkali/builder/spec"
+)
+
+var (
+	errAnnotation = errors.New("invalid annotation")
+	errMediaTypes = errors.New("invalid mediatypes")
+)
+
+// AnnotationType is the object an annotation is attached to.
+type AnnotationType string
+
+const (
+	AnnotationManifest           AnnotationType = "manifest"
+	AnnotationManifestDescriptor AnnotationType = "manifest-descriptor"
+	AnnotationIndex              AnnotationType = "index"
+	AnnotationIndexDescriptor    AnnotationType = "index-descriptor"
+)
+
+const annotationPrefix = "annotation"
+
+// Annotation is attached to the image. Type defaults to manifest, and an empty Platform (eg: `linux/arm64`) means all
+// platforms.
+type Annotation struct {
+	Type     AnnotationType
+	Platform string
+	Key      string
+	Value    string
+}
+
+// Attr is the exporter attribute key for that annotation: annotation-<type>[<platform>].<key>
+func (o *Annotation) Attr() string {
+	key := annotationPrefix
+
+	if o.Type != "" {
+		key += "-" + string(o.Type)
+	}
+
+	if o.Platform != "" {
+		key += "[" + o.Platform + "]"
+	}
+
+	return key + "." + o.Key
+}
+
+// Image creates an image, stored in the worker image store and optionally pushed.
+// Zero values keep buildkit defaults, except for compression (estargz, or gzip with docker mediatypes) and mediatypes
+// (OCI).
+type Image struct {
+	Names []string
+	// Name is added to Names.
+	//
+	// Deprecated: use Names.
+	Name string
+	Push bool
+	// PushByDigest pushes unnamed images, only referenced by their digest
+	PushByDigest bool
+	// Insecure allows pushing to registries over plain HTTP
+	Insecure bool
+	// DockerMediaTypes uses docker mediatypes instead of OCI (incompatible with estargz, hence defaults to gzip)
+	DockerMediaTypes bool
+	Compression      cache.Compression
+	// CompressionLevel is only used if set (0 leaves the buildkit default)
+	CompressionLevel int
+	// ForceCompression recompresses layers that are already compressed with another algorithm
+	ForceCompression bool
+	// DanglingNamePrefix names unnamed images prefix@<digest>
+	DanglingNamePrefix string
+	// NameCanonical adds name@<digest> to every name
+	NameCanonical bool
+	// Unpack the image after creation (containerd workers)
+	Unpack bool
+	// NoStore skips storing the image in the worker image store (containerd workers)
+	NoStore     bool
+	Annotations []*Annotation
+}
+
+func (o *Image) String() string {
+	entry := o.GetEntry()
+
+	return spec.Format(entry.Type, entry.Attrs)
+}
+
+func (o *Image) compression() cache.Compression {
+	switch {
+	case o.Compression != "":
+		return o.Compression
+	case o.DockerMediaTypes:
+		return cache.CompressionGzip
+	}
+
+	return cache.CompressionEstargz
+}
+
+// AllNames returns Names, along with the deprecated Name.
+func (o *Image) AllNames() []string {
+	if o.Name == "" {
+		return o.Names
+	}
+
+	return append([]string{o.Name}, o.Names...)
+}
+
+// Validate checks for combinations buildkit would reject, or silently mishandle.
+func (o *Image) Validate() error {
+	if err := o.compression().ValidateLevel(o.CompressionLevel); err != nil {
+		return err
+	}
+
+	if o.DockerMediaTypes && o.compression() == cache.CompressionEstargz {
+		return fmt.Errorf("%w: estargz requires OCI mediatypes", errMediaTypes)
+	}
+
+	for _, annotation := range o.Annotations {
+		if annotation.Key == "" {
+			return fmt.Errorf("%w: empty key", errAnnotation)
+		}
+
+		switch annotation.Type {
+		case "", AnnotationManifest, AnnotationManifestDescriptor, AnnotationIndex, AnnotationIndexDescriptor:
+		default:
+			return fmt.Errorf("%w type %q", errAnnotation, annotation.Type)
+		}
+	}
+
+	return nil
+}
+
+func (o *Image) GetEntry() client.ExportEntry {
+	attrs := map[string]string{
+		"name":           strings.Join(o.AllNames(), ","),
+		"push":           strconv.FormatBool(o.Push),
+		"compression":    string(o.compression()),
+		"oci-mediatypes": strconv.FormatBool(!o.DockerMediaTypes),
+	}
+
+	if o.PushByDigest {
+		attrs["push-by-digest"] = "true"
+	}
+
+	if o.Insecure {
+		attrs["registry.insecure"] = "true"
+	}
+
+	if o.CompressionLevel != 0 {
+		attrs["compression-level"] = strconv.Itoa(o.CompressionLevel)
+	}
+
+	if o.ForceCompression {
+		attrs["force-compression"] = "true"
+	}
+
+	if o.DanglingNamePrefix != "" {
+		attrs["dangling-name-prefix"] = o.DanglingNamePrefix
+	}
+
+	if o.NameCanonical {
+		attrs["name-canonical"] = "true"
+	}
+
+	if o.Unpack {
+		attrs["unpack"] = "true"
+	}
+
+	if o.NoStore {
+		attrs["store"] = "false"
+	}
+
+	for _, annotation := range o.Annotations {
+		attrs[annotation.Attr()] = annotation.Value
+	}
+
+	return client.ExportEntry{
+		Type:  string(typeImage),
+		Attrs: attrs,
+	}
+}
+
+// parseAnnotation reads an annotation attribute key (annotation-<type>[<platform>].<key>). ok is false if key is not
+// an annotation.
+func parseAnnotation(key string, value string) (*Annotation, bool, error) {
+	if !strings.HasPrefix(key, annotationPrefix+".") && !strings.HasPrefix(key, annotationPrefix+"-") &&
+		!strings.HasPrefix(key, annotationPrefix+"[") {
+		return nil, false, nil
+	}
+
+	head, name, found := strings.Cut(strings.TrimPrefix(key, annotationPrefix), ".")
+	if !found || name == "" {
+		return nil, true, fmt.Errorf("%w %q: missing key", errAnnotation, key)
+	}
+
+	annotation := &Annotation{Key: name, Value: value}
+
+	if idx := strings.Index(head, "["); idx != -1 {
+		annotation.Platform = strings.TrimSuffix(head[idx+1:], "]")
+		head = head[:idx]
+	}
+
+	annotation.Type = AnnotationType(strings.TrimPrefix(head, "-"))
+
+	return annotation, true, nil
+}
@@ -0,0 +1,45 @@
+package exporter_test
+
+import (
+	"testing"
+
+	"go.codecomet.dev/alkali/builder/cache"
+	"go.codecomet.dev/alkali/builder/exporter"
+)
+
+func TestImageDockerMediaTypes(t *testing.T) {
+	t.Parallel()
+
+	image := &exporter.Image{Names: []string{"app"}, DockerMediaTypes: true}
+	if err := image.Validate(); err != nil {
+		t.Fatalf("docker mediatypes alone must be valid: %v", err)
+	}
+
+	if attrs := image.GetEntry().Attrs; attrs["compression"] != "gzip" || attrs["oci-mediatypes"] != "false" {
+		t.Errorf("unexpected attributes %v", attrs)
+	}
+
+	parsed, err := exporter.Parse("type=image,name=app,oci-mediatypes=false")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if parsed.GetEntry().Attrs["compression"] != "gzip" {
+		t.Errorf("unexpected attributes %v", parsed.GetEntry().Attrs)
+	}
+
+	image.Compression = cache.CompressionEstargz
+	if err = exporter.Validate([]exporter.Entry{&exporter.Image{}, image}); err == nil {
+		t.Error("expected an error for estargz with docker mediatypes")
+	}
+}
+
+func TestImageName(t *testing.T) {
+	t.Parallel()
+
+	image := &exporter.Image{Name: "registry.local/app:1.0", Names: []string{"registry.local/app:latest"}}
+
+	if name := image.GetEntry().Attrs["name"]; name != "registry.local/app:1.0,registry.local/app:latest" {
+		t.Errorf("expected the deprecated name to be kept, got %q", name)
+	}
+}
@@ -0,0 +1,193 @@
+package exporter
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"go.codecomet.dev/alkali/builder/cache"
+	"go.codecomet.dev/alkali/builder/spec"
+)
+
+var (
+	errUnknownType = errors.New("unknown exporter type")
+	errDest        = errors.New("invalid exporter destination")
+)
+
+const (
+	tarSuffix  = ".tar"
+	stdoutDest = "-"
+)
+
+// Parse reads a buildctl-style output spec, like `type=local,dest=path` or `type=image,name=example.com/foo,push=true`.
+// `type=docker` loads the result into the local docker daemon, instead of writing a tarball.
+// Unknown keys are rejected.
+// Since tarball outputs are inferred from the destination, `type=tar` and `type=oci,tar=true` require a .tar dest,
+// while `type=local` and `type=oci,tar=false` require anything else. Tarballs can be written to stdout with `dest=-`.
+func Parse(value string) (Entry, error) {
+	typ, attrs, err := spec.Parse(value)
+	if err != nil {
+		return nil, err
+	}
+
+	switch exporterType(typ) {
+	case typeImage:
+		return parseImage(attrs)
+	case typeLocal, typeTar:
+		if err := attrs.Check(typ, "dest"); err != nil {
+			return nil, err
+		}
+
+		dest := attrs.String("dest")
+		if dest == stdoutDest && exporterType(typ) == typeTar {
+			return &Stream{Writer: Stdout()}, nil
+		}
+
+		if err := checkDest(dest, exporterType(typ) == typeTar); err != nil {
+			return nil, err
+		}
+
+		return &Local{Path: dest}, nil
+	case typeOCI:
+		if err := attrs.Check(typ, "dest", "tar"); err != nil {
+			return nil, err
+		}
+
+		tar := true
+		if _, ok := attrs["tar"]; ok {
+			if tar, err = attrs.Bool("tar"); err != nil {
+				return nil, err
+			}
+		}
+
+		dest := attrs.String("dest")
+		if dest == stdoutDest && tar {
+			return &Stream{Writer: Stdout(), OCI: true}, nil
+		}
+
+		if err := checkDest(dest, tar); err != nil {
+			return nil, err
+		}
+
+		return &Local{Path: dest, OCI: true}, nil
+	case typeDocker:
+		if err := attrs.Check(typ, "name"); err != nil {
+			return nil, err
+		}
+
+		return &Docker{Names: splitNames(attrs.String("name"))}, nil
+	}
+
+	return nil, fmt.Errorf("%w %q", errUnknownType, typ)
+}
+
+func checkDest(dest string, tar bool) error {
+	switch {
+	case dest == "":
+		return fmt.Errorf("%w: dest is required", errDest)
+	case dest == stdoutDest:
+		return fmt.Errorf("%w: only tarballs can be written to stdout", errDest)
+	case tar && !strings.HasSuffix(dest, tarSuffix):
+		return fmt.Errorf("%w %q: tarball outputs must end with %s", errDest, dest, tarSuffix)
+	case !tar && strings.HasSuffix(dest, tarSuffix):
+		return fmt.Errorf("%w %q: directory outputs must not end with %s", errDest, dest, tarSuffix)
+	}
+
+	return nil
+}
+
+// Names are comma separated, as with buildctl (eg: `type=docker,"name=foo:1,foo:latest"`)
+func splitNames(value string) []string {
+	names := []string{}
+
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
+
+func parseImage(attrs spec.Attrs) (Entry, error) {
+	entry := &Image{
+		Names:              splitNames(attrs.String("name")),
+		DanglingNamePrefix: attrs.String("dangling-name-prefix"),
+		Annotations:        []*Annotation{},
+	}
+
+	// Annotations have free form keys, so they are taken out before checking the others
+	rest := spec.Attrs{}
+	keys := make([]string, 0, len(attrs))
+
+	for key := range attrs {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		annotation, ok, err := parseAnnotation(key, attrs[key])
+		if err != nil {
+			return nil, err
+		}
+
+		if ok {
+			entry.Annotations = append(entry.Annotations, annotation)
+		} else {
+			rest[key] = attrs[key]
+		}
+	}
+
+	if err := rest.Check(string(typeImage), "name", "push", "push-by-digest", "registry.insecure", "oci-mediatypes",
+		"compression", "compression-level", "force-compression", "dangling-name-prefix", "name-canonical", "unpack",
+		"store"); err != nil {
+		return nil, err
+	}
+
+	var err error
+
+	bools := map[string]*bool{
+		"push":              &entry.Push,
+		"push-by-digest":    &entry.PushByDigest,
+		"registry.insecure": &entry.Insecure,
+		"force-compression": &entry.ForceCompression,
+		"name-canonical":    &entry.NameCanonical,
+		"unpack":            &entry.Unpack,
+	}
+
+	for key, field := range bools {
+		if *field, err = rest.Bool(key); err != nil {
+			return nil, err
+		}
+	}
+
+	// Those two default to true
+	for key, field := range map[string]*bool{"oci-mediatypes": &entry.DockerMediaTypes, "store": &entry.NoStore} {
+		if rest.String(key) == "" {
+			continue
+		}
+
+		value, err := rest.Bool(key)
+		if err != nil {
+			return nil, err
+		}
+
+		*field = !value
+	}
+
+	if entry.Compression, err = cache.ParseCompression(rest.String("compression")); err != nil {
+		return nil, err
+	}
+
+	if entry.CompressionLevel, err = rest.Int("compression-level"); err != nil {
+		return nil, err
+	}
+
+	if err = entry.Validate(); err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
@@ -0,0 +1,145 @@
+package exporter_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"go.codecomet.dev/alkali/builder/exporter"
+	"go.codecomet.dev/alkali/builder/spec"
+)
+
+func TestParseUnknownKeys(t *testing.T) {
+	t.Parallel()
+
+	for _, value := range []string{
+		"type=local,dest=out,bogus=1",
+		"type=tar,dest=out.tar,bogus=1",
+		"type=oci,dest=out.tar,bogus=1",
+		"type=docker,name=app,bogus=1",
+		"type=image,name=app,bogus=1",
+	} {
+		if _, err := exporter.Parse(value); !errors.Is(err, spec.ErrUnknownKey) {
+			t.Errorf("%s: expected an unknown key error, got %v", value, err)
+		}
+	}
+}
+
+func TestParseDest(t *testing.T) {
+	t.Parallel()
+
+	for _, test := range []struct {
+		value string
+		entry exporter.Entry
+	}{
+		{"type=local,dest=out", &exporter.Local{Path: "out"}},
+		{"type=tar,dest=out.tar", &exporter.Local{Path: "out.tar"}},
+		{"type=oci,dest=out.tar", &exporter.Local{Path: "out.tar", OCI: true}},
+		{"type=oci,dest=out,tar=false", &exporter.Local{Path: "out", OCI: true}},
+	} {
+		entry, err := exporter.Parse(test.value)
+		if err != nil {
+			t.Errorf("%s: %v", test.value, err)
+
+			continue
+		}
+
+		if !reflect.DeepEqual(entry, test.entry) {
+			t.Errorf("%s: unexpected entry %+v", test.value, entry)
+		}
+	}
+
+	for _, value := range []string{
+		"type=local",
+		"type=local,dest=-",
+		"type=local,dest=out.tar",
+		"type=tar,dest=out",
+		"type=oci,dest=out",
+		"type=oci,dest=out.tar,tar=false",
+		"type=oci,dest=-,tar=false",
+	} {
+		if _, err := exporter.Parse(value); err == nil {
+			t.Errorf("%s: expected an error", value)
+		}
+	}
+}
+
+func TestParseStdout(t *testing.T) {
+	t.Parallel()
+
+	for value, oci := range map[string]bool{"type=tar,dest=-": false, "type=oci,dest=-": true} {
+		entry, err := exporter.Parse(value)
+		if err != nil {
+			t.Errorf("%s: %v", value, err)
+
+			continue
+		}
+
+		stream, ok := entry.(*exporter.Stream)
+		if !ok || stream.OCI != oci || stream.Writer == nil {
+			t.Errorf("%s: expected a stream to stdout, got %+v", value, entry)
+		}
+	}
+}
+
+func TestParseNames(t *testing.T) {
+	t.Parallel()
+
+	entry, err := exporter.Parse(`type=docker,"name=app:1, app:latest"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if docker := entry.(*exporter.Docker); !reflect.DeepEqual(docker.Names, []string{"app:1", "app:latest"}) {
+		t.Errorf("unexpected names %v", docker.Names)
+	}
+
+	entry, err = exporter.Parse(`type=image,"name=registry.local/app:1,registry.local/app:latest",push=true`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	image := entry.(*exporter.Image)
+	if !image.Push || !reflect.DeepEqual(image.Names, []string{"registry.local/app:1", "registry.local/app:latest"}) {
+		t.Errorf("unexpected image %+v", image)
+	}
+}
+
+func TestParseAnnotations(t *testing.T) {
+	t.Parallel()
+
+	entry, err := exporter.Parse("type=image,name=app," +
+		"annotation.org.opencontainers.image.title=app," +
+		"annotation-index.org.opencontainers.image.version=1.0," +
+		"annotation[linux/amd64].org.opencontainers.image.description=amd64," +
+		"annotation-manifest-descriptor[linux/arm64].org.opencontainers.image.description=arm64")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Sorted by attribute
+	expected := []*exporter.Annotation{
+		{Type: exporter.AnnotationIndex, Key: "org.opencontainers.image.version", Value: "1.0"},
+		{Type: exporter.AnnotationManifestDescriptor, Platform: "linux/arm64",
+			Key: "org.opencontainers.image.description", Value: "arm64"},
+		{Key: "org.opencontainers.image.title", Value: "app"},
+		{Platform: "linux/amd64", Key: "org.opencontainers.image.description", Value: "amd64"},
+	}
+
+	if annotations := entry.(*exporter.Image).Annotations; !reflect.DeepEqual(annotations, expected) {
+		for _, annotation := range annotations {
+			t.Logf("%+v", annotation)
+		}
+
+		t.Error("unexpected annotations")
+	}
+
+	for _, value := range []string{
+		"type=image,name=app,annotation.=value",
+		"type=image,name=app,annotation-bogus.key=value",
+	} {
+		if _, err = exporter.Parse(value); err == nil {
+			t.Errorf("%s: expected an error", value)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package exporter
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/remotes"
+	"github.com/moby/buildkit/exporter/containerimage/exptypes"
+	ocispecs "github.com/opencontainers/image-spec/specs-go/v1"
+	"go.codecomet.dev/alkali/builder/registry"
+	"go.codecomet.dev/containers/digest"
+)
+
+const (
+	imageNameKey = "image.name"
+
+	// Buildkit attaches attestations to the index, as manifests referring to the platform manifest they describe
+	referenceTypeAnnotation   = "vnd.docker.reference.type"
+	referenceDigestAnnotation = "vnd.docker.reference.digest"
+	attestationManifestType   = "attestation-manifest"
+)
+
+// Response is the typed version of the exporter response returned by buildkit.
+type Response struct {
+	// Digest of the image (of the index, for multi-platform images)
+	Digest       digest.Digest
+	ConfigDigest digest.Digest
+	Descriptor   *ocispecs.Descriptor
+	Names        []string
+	// OCILayout is where the OCI layout was exported, if any (a directory, or a tarball)
+	OCILayout string
+	// Platforms maps platforms (eg: `linux/arm64`) to their manifest digest, for multi-platform images.
+	// Buildkit does not report them: they are read from the exported OCI layout directory, or from the pushed index.
+	Platforms map[string]digest.Digest
+	// Attestations maps platform manifest digests to their attestation manifest (provenance, SBOM).
+	// Same as Platforms, they are read from the exported OCI layout directory, or from the pushed index.
+	Attestations map[digest.Digest]digest.Digest
+	// Raw is the untyped response
+	Raw map[string]string
+}
+
+// ParseResponse types the exporter response of a solve.
+func ParseResponse(resp map[string]string) (*Response, error) {
+	ret := &Response{
+		Digest:       digest.Digest(resp[exptypes.ExporterImageDigestKey]),
+		ConfigDigest: digest.Digest(resp[exptypes.ExporterImageConfigDigestKey]),
+		Names:        splitNames(resp[imageNameKey]),
+		Platforms:    map[string]digest.Digest{},
+		Attestations: map[digest.Digest]digest.Digest{},
+		Raw:          resp,
+	}
+
+	if encoded := resp[exptypes.ExporterImageDescriptorKey]; encoded != "" {
+		byt, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("could not decode %s: %w", exptypes.ExporterImageDescriptorKey, err)
+		}
+
+		ret.Descriptor = &ocispecs.Descriptor{}
+		if err = json.Unmarshal(byt, ret.Descriptor); err != nil {
+			return nil, fmt.Errorf("could not unmarshal %s: %w", exptypes.ExporterImageDescriptorKey, err)
+		}
+	}
+
+	return ret, nil
+}
+
+// WithOCILayout records where an OCI layout was exported, and reads per-platform digests from it if it is a directory.
+func (o *Response) WithOCILayout(path string) error {
+	o.OCILayout = path
+
+	if strings.HasSuffix(path, tarSuffix) {
+		return nil
+	}
+
+	index, err := readBlob[ocispecs.Index](filepath.Join(path, "index.json"))
+	if err != nil {
+		return err
+	}
+
+	for _, desc := range index.Manifests {
+		if !images.IsIndexType(desc.MediaType) {
+			continue
+		}
+
+		blob := filepath.Join(path, "blobs", desc.Digest.Algorithm().String(), desc.Digest.Encoded())
+
+		child, err := readBlob[ocispecs.Index](blob)
+		if err != nil {
+			return err
+		}
+
+		o.addManifests(child.Manifests)
+	}
+
+	return nil
+}
+
+// WithRegistry reads per-platform digests from the index pushed to repository (eg: `docker.io/org/app`). Single
+// platform images have none.
+func (o *Response) WithRegistry(ctx context.Context, resolver remotes.Resolver, repository string) error {
+	desc := o.Descriptor
+	if desc == nil {
+		if o.Digest == "" {
+			return nil
+		}
+
+		_, resolved, err := resolver.Resolve(ctx, repository+"@"+o.Digest.String())
+		if err != nil {
+			return err
+		}
+
+		desc = &resolved
+	}
+
+	// Docker manifest lists are indexes too
+	if !images.IsIndexType(desc.MediaType) {
+		return nil
+	}
+
+	var index ocispecs.Index
+	if err := registry.FetchJSON(ctx, resolver, repository, *desc, &index); err != nil {
+		return err
+	}
+
+	o.addManifests(index.Manifests)
+
+	return nil
+}
+
+// addManifests records the platform manifests and attestations of an index
+func (o *Response) addManifests(manifests []ocispecs.Descriptor) {
+	for _, manifest := range manifests {
+		if manifest.Annotations[referenceTypeAnnotation] == attestationManifestType {
+			subject := digest.Digest(manifest.Annotations[referenceDigestAnnotation])
+			o.Attestations[subject] = manifest.Digest
+
+			continue
+		}
+
+		if manifest.Platform == nil {
+			continue
+		}
+
+		o.Platforms[formatPlatform(manifest.Platform)] = manifest.Digest
+	}
+}
+
+func readBlob[T any](path string) (*T, error) {
+	byt, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var ret T
+	if err = json.Unmarshal(byt, &ret); err != nil {
+		return nil, fmt.Errorf("could not unmarshal %s: %w", path, err)
+	}
+
+	return &ret, nil
+}
+
+func formatPlatform(plt *ocispecs.Platform) string {
+	ret := plt.OS + "/" + plt.Architecture
+	if plt.Variant != "" {
+		ret += "/" + plt.Variant
+	}
+
+	return ret
+}
@@ -0,0 +1,116 @@
+package exporter_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/containerd/containerd/images"
+	ocispecs "github.com/opencontainers/image-spec/specs-go/v1"
+	"go.codecomet.dev/alkali/builder/exporter"
+	"go.codecomet.dev/alkali/builder/internal/fakeregistry"
+	"go.codecomet.dev/alkali/builder/registry"
+	"go.codecomet.dev/containers/digest"
+)
+
+// pushIndex stores an index of the given mediatype, and returns the exporter response describing it
+func pushIndex(
+	t *testing.T,
+	reg *fakeregistry.Registry,
+	mediaType string,
+	manifests ...ocispecs.Descriptor,
+) *exporter.Response {
+	t.Helper()
+
+	byt, err := json.Marshal(map[string]any{"schemaVersion": 2, "mediaType": mediaType, "manifests": manifests})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dgst := reg.PutManifest("org/app", "1.0", mediaType, byt)
+
+	resp, err := exporter.ParseResponse(map[string]string{"containerimage.digest": dgst.String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return resp
+}
+
+func TestResponseWithRegistry(t *testing.T) {
+	t.Parallel()
+
+	reg, err := fakeregistry.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer reg.Close()
+
+	amd64 := digest.FromBytes([]byte("amd64"))
+	armv7 := digest.FromBytes([]byte("arm/v7"))
+
+	resp := pushIndex(t, reg, images.MediaTypeDockerSchema2ManifestList,
+		ocispecs.Descriptor{
+			MediaType: images.MediaTypeDockerSchema2Manifest,
+			Digest:    amd64,
+			Platform:  &ocispecs.Platform{OS: "linux", Architecture: "amd64"},
+		},
+		ocispecs.Descriptor{
+			MediaType: images.MediaTypeDockerSchema2Manifest,
+			Digest:    armv7,
+			Platform:  &ocispecs.Platform{OS: "linux", Architecture: "arm", Variant: "v7"},
+		},
+	)
+
+	if err = resp.WithRegistry(context.Background(), registry.New().Resolver(), reg.Host()+"/org/app"); err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.Platforms["linux/amd64"] != amd64 || resp.Platforms["linux/arm/v7"] != armv7 || len(resp.Platforms) != 2 {
+		t.Errorf("unexpected platforms %v", resp.Platforms)
+	}
+}
+
+func TestResponseAttestationsWithRegistry(t *testing.T) {
+	t.Parallel()
+
+	reg, err := fakeregistry.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer reg.Close()
+
+	image := digest.FromBytes([]byte("image"))
+	attestation := digest.FromBytes([]byte("attestation"))
+
+	resp := pushIndex(t, reg, ocispecs.MediaTypeImageIndex,
+		ocispecs.Descriptor{
+			MediaType: ocispecs.MediaTypeImageManifest,
+			Digest:    image,
+			Platform:  &ocispecs.Platform{OS: "linux", Architecture: "arm64"},
+		},
+		ocispecs.Descriptor{
+			MediaType: ocispecs.MediaTypeImageManifest,
+			Digest:    attestation,
+			Platform:  &ocispecs.Platform{OS: "unknown", Architecture: "unknown"},
+			Annotations: map[string]string{
+				"vnd.docker.reference.type":   "attestation-manifest",
+				"vnd.docker.reference.digest": image.String(),
+			},
+		},
+	)
+
+	if err = resp.WithRegistry(context.Background(), registry.New().Resolver(), reg.Host()+"/org/app"); err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.Attestations[image] != attestation {
+		t.Errorf("unexpected attestations %v", resp.Attestations)
+	}
+
+	if len(resp.Platforms) != 1 {
+		t.Errorf("attestations must not be listed as platforms: %v", resp.Platforms)
+	}
+}
@@ -0,0 +1,158 @@
+package exporter
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sync/atomic"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/moby/buildkit/client"
+	"go.codecomet.dev/alkali/builder/spec"
+	"go.codecomet.dev/core/filesystem"
+)
+
+var errStreamCompression = errors.New("invalid stream compression")
+
+// StreamCompression is applied client side, on the whole tarball.
+type StreamCompression string
+
+const (
+	StreamUncompressed StreamCompression = ""
+	StreamGzip         StreamCompression = "gzip"
+	StreamZstd         StreamCompression = "zstd"
+)
+
+// WriterFactory opens the destination of a stream. It is called once buildkit starts sending the result.
+type WriterFactory func() (io.WriteCloser, error)
+
+// Stdout writes to the standard output (which is not closed).
+func Stdout() WriterFactory {
+	return func() (io.WriteCloser, error) {
+		return nopCloser{os.Stdout}, nil
+	}
+}
+
+// File creates (or truncates) the file at path, creating parent directories if need be.
+func File(filePath string) WriterFactory {
+	return func() (io.WriteCloser, error) {
+		// XXX this is problematic right now as this is not using the config permissions
+		// Still wondering if this should be static instead
+		if err := os.MkdirAll(path.Dir(filePath), filesystem.DirPermissionsDefault); err != nil {
+			return nil, err
+		}
+
+		return os.Create(filePath)
+	}
+}
+
+// Stream sends the result as a tarball (or an OCI layout tarball) to any writer: stdout, an upload, a buffer...
+type Stream struct {
+	Writer      WriterFactory
+	OCI         bool
+	Compression StreamCompression
+
+	written int64
+}
+
+func (o *Stream) String() string {
+	entry := o.GetEntry()
+	attrs := map[string]string{"dest": "<stream>"}
+
+	if o.OCI {
+		attrs["tar"] = entry.Attrs["tar"]
+	}
+
+	return spec.Format(entry.Type, attrs)
+}
+
+// Written returns how many bytes were written to the destination so far (after compression).
+func (o *Stream) Written() int64 {
+	return atomic.LoadInt64(&o.written)
+}
+
+func (o *Stream) GetEntry() client.ExportEntry {
+	clientExport := client.ExportEntry{
+		Type:  string(typeTar),
+		Attrs: map[string]string{},
+		Output: func(map[string]string) (io.WriteCloser, error) {
+			return o.open()
+		},
+	}
+
+	if o.OCI {
+		clientExport.Type = string(typeOCI)
+		clientExport.Attrs["tar"] = "true"
+	}
+
+	return clientExport
+}
+
+func (o *Stream) open() (io.WriteCloser, error) {
+	atomic.StoreInt64(&o.written, 0)
+
+	dest, err := o.Writer()
+	if err != nil {
+		return nil, err
+	}
+
+	counted := &countingWriter{WriteCloser: dest, written: &o.written}
+
+	switch o.Compression {
+	case StreamUncompressed:
+		return counted, nil
+	case StreamGzip:
+		return &compressedWriter{WriteCloser: gzip.NewWriter(counted), dest: counted}, nil
+	case StreamZstd:
+		encoder, err := zstd.NewWriter(counted)
+		if err != nil {
+			counted.Close()
+
+			return nil, err
+		}
+
+		return &compressedWriter{WriteCloser: encoder, dest: counted}, nil
+	}
+
+	counted.Close()
+
+	return nil, fmt.Errorf("%w %q", errStreamCompression, o.Compression)
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+type countingWriter struct {
+	io.WriteCloser
+	written *int64
+}
+
+func (o *countingWriter) Write(p []byte) (int, error) {
+	n, err := o.WriteCloser.Write(p)
+	atomic.AddInt64(o.written, int64(n))
+
+	return n, err
+}
+
+// compressedWriter flushes the compressor, then closes the destination
+type compressedWriter struct {
+	io.WriteCloser
+	dest io.Closer
+}
+
+func (o *compressedWriter) Close() error {
+	err := o.WriteCloser.Close()
+	if closeErr := o.dest.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
@@ -0,0 +1,167 @@
+package exporter_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"go.codecomet.dev/alkali/builder/exporter"
+)
+
+// buffer records what was written, and whether it was closed
+type buffer struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (o *buffer) Close() error {
+	o.closed = true
+
+	return nil
+}
+
+func stream(t *testing.T, compression exporter.StreamCompression, data []byte) (*exporter.Stream, *buffer) {
+	t.Helper()
+
+	dest := &buffer{}
+	entry := &exporter.Stream{
+		Writer: func() (io.WriteCloser, error) {
+			return dest, nil
+		},
+		Compression: compression,
+	}
+
+	writer, err := entry.GetEntry().Output(map[string]string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = writer.Write(data); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !dest.closed {
+		t.Error("expected the destination to be closed")
+	}
+
+	return entry, dest
+}
+
+func TestStreamCompression(t *testing.T) {
+	t.Parallel()
+
+	data := bytes.Repeat([]byte("tarball content "), 1024)
+
+	decompress := map[exporter.StreamCompression]func(io.Reader) (io.Reader, error){
+		exporter.StreamUncompressed: func(reader io.Reader) (io.Reader, error) {
+			return reader, nil
+		},
+		exporter.StreamGzip: func(reader io.Reader) (io.Reader, error) {
+			return gzip.NewReader(reader)
+		},
+		exporter.StreamZstd: func(reader io.Reader) (io.Reader, error) {
+			return zstd.NewReader(reader)
+		},
+	}
+
+	for compression, reader := range decompress {
+		entry, dest := stream(t, compression, data)
+
+		if entry.Written() != int64(dest.Len()) {
+			t.Errorf("%q: written %d, destination has %d", compression, entry.Written(), dest.Len())
+		}
+
+		if compression != exporter.StreamUncompressed && dest.Len() >= len(data) {
+			t.Errorf("%q: output is not compressed (%d bytes)", compression, dest.Len())
+		}
+
+		decompressed, err := reader(bytes.NewReader(dest.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		byt, err := io.ReadAll(decompressed)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(byt, data) {
+			t.Errorf("%q: decompressed output differs from the input", compression)
+		}
+	}
+}
+
+func TestStreamInvalidCompression(t *testing.T) {
+	t.Parallel()
+
+	dest := &buffer{}
+	entry := &exporter.Stream{
+		Writer: func() (io.WriteCloser, error) {
+			return dest, nil
+		},
+		Compression: "lz4",
+	}
+
+	if _, err := entry.GetEntry().Output(map[string]string{}); err == nil {
+		t.Error("expected an error for an unknown compression")
+	}
+
+	if !dest.closed {
+		t.Error("expected the destination to be closed")
+	}
+}
+
+func TestStreamStdout(t *testing.T) { //nolint:paralleltest
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer reader.Close()
+	defer writer.Close()
+
+	stdout := os.Stdout
+	os.Stdout = writer
+
+	defer func() {
+		os.Stdout = stdout
+	}()
+
+	entry := &exporter.Stream{Writer: exporter.Stdout(), Compression: exporter.StreamGzip}
+
+	output, err := entry.GetEntry().Output(map[string]string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = output.Write([]byte("tarball")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = output.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Stdout belongs to the caller, and must still be usable
+	if _, err = writer.Write([]byte("after")); err != nil {
+		t.Fatalf("stdout was closed: %v", err)
+	}
+
+	writer.Close()
+
+	byt, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if int64(len(byt)) != entry.Written()+int64(len("after")) {
+		t.Errorf("written %d, stdout received %d", entry.Written(), len(byt)-len("after"))
+	}
+}
@@ -0,0 +1,180 @@
+package image
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/containerd/containerd/platforms"
+	ocispecs "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const (
+	defaultProtocol = "tcp"
+	maxPort         = 65535
+)
+
+var (
+	errPort       = errors.New("invalid port")
+	errNoPlatform = errors.New("image config requires a platform")
+)
+
+// Config holds the runtime configuration of an exported image, so that LLB authors do not have to encode OCI image
+// config JSON themselves. Fields left empty are left out of the image config.
+//
+// The config replaces the config of the base image entirely: nothing is inherited from it (env, including PATH,
+// entrypoint, cmd, working dir, user, labels...), and anything the image needs has to be set here.
+type Config struct {
+	// Platform must match what the definition builds. Builds fill it in from the definition when left nil.
+	Platform     *ocispecs.Platform
+	Labels       map[string]string
+	Env          []string
+	Entrypoint   []string
+	Cmd          []string
+	WorkingDir   string
+	User         string
+	ExposedPorts []string
+	StopSignal   string
+}
+
+func NewConfig() *Config {
+	return &Config{
+		Labels:       map[string]string{},
+		Env:          []string{},
+		ExposedPorts: []string{},
+	}
+}
+
+func (o *Config) SetLabel(key string, value string) *Config {
+	if o.Labels == nil {
+		o.Labels = map[string]string{}
+	}
+
+	o.Labels[key] = value
+
+	return o
+}
+
+// SetEnv sets (or replaces) an environment variable.
+func (o *Config) SetEnv(key string, value string) *Config {
+	for idx, env := range o.Env {
+		if k, _, _ := strings.Cut(env, "="); k == key {
+			o.Env[idx] = key + "=" + value
+
+			return o
+		}
+	}
+
+	o.Env = append(o.Env, key+"="+value)
+
+	return o
+}
+
+func (o *Config) SetEntrypoint(args ...string) *Config {
+	o.Entrypoint = args
+
+	return o
+}
+
+func (o *Config) SetCmd(args ...string) *Config {
+	o.Cmd = args
+
+	return o
+}
+
+func (o *Config) SetWorkingDir(dir string) *Config {
+	o.WorkingDir = dir
+
+	return o
+}
+
+func (o *Config) SetUser(user string) *Config {
+	o.User = user
+
+	return o
+}
+
+// Expose adds a port (eg: `80`, `53/udp`). The protocol defaults to tcp.
+func (o *Config) Expose(port string) *Config {
+	o.ExposedPorts = append(o.ExposedPorts, port)
+
+	return o
+}
+
+func (o *Config) SetStopSignal(signal string) *Config {
+	o.StopSignal = signal
+
+	return o
+}
+
+// ForPlatform returns a copy of the config, for the given platform (used by multi-platform runs).
+func (o *Config) ForPlatform(platform ocispecs.Platform) *Config {
+	cfg := *o
+	cfg.Platform = &platform
+
+	return &cfg
+}
+
+func normalizePort(port string) (string, error) {
+	number, protocol, found := strings.Cut(port, "/")
+	if !found {
+		protocol = defaultProtocol
+	}
+
+	value, err := strconv.Atoi(number)
+	if err != nil || value < 1 || value > maxPort {
+		return "", fmt.Errorf("%w %q", errPort, port)
+	}
+
+	switch protocol = strings.ToLower(protocol); protocol {
+	case "tcp", "udp", "sctp":
+	default:
+		return "", fmt.Errorf("%w %q: unknown protocol %s", errPort, port, protocol)
+	}
+
+	return strconv.Itoa(value) + "/" + protocol, nil
+}
+
+// ToJSON renders the image config, as expected by buildkit in the `containerimage.config` result metadata. Rootfs and
+// history are filled in by the exporter.
+func (o *Config) ToJSON() ([]byte, error) {
+	if o.Platform == nil {
+		return nil, errNoPlatform
+	}
+
+	plt := platforms.Normalize(*o.Platform)
+	img := ocispecs.Image{
+		Architecture: plt.Architecture,
+		Variant:      plt.Variant,
+		OS:           plt.OS,
+		Config: ocispecs.ImageConfig{
+			Labels:     o.Labels,
+			Env:        o.Env,
+			Entrypoint: o.Entrypoint,
+			Cmd:        o.Cmd,
+			WorkingDir: o.WorkingDir,
+			User:       o.User,
+			StopSignal: o.StopSignal,
+		},
+		RootFS: ocispecs.RootFS{
+			Type: "layers",
+		},
+	}
+
+	if len(o.ExposedPorts) > 0 {
+		img.Config.ExposedPorts = map[string]struct{}{}
+
+		for _, port := range o.ExposedPorts {
+			normalized, err := normalizePort(port)
+			if err != nil {
+				return nil, err
+			}
+
+			img.Config.ExposedPorts[normalized] = struct{}{}
+		}
+	}
+
+	return json.Marshal(img)
+}
@@ -0,0 +1,36 @@
+package image_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	ocispecs "github.com/opencontainers/image-spec/specs-go/v1"
+	"go.codecomet.dev/alkali/builder/image"
+)
+
+func TestConfigPlatform(t *testing.T) {
+	t.Parallel()
+
+	config := image.NewConfig().SetEnv("PATH", "/bin")
+	if _, err := config.ToJSON(); err == nil {
+		t.Fatal("expected a config without platform to be refused")
+	}
+
+	byt, err := config.ForPlatform(ocispecs.Platform{OS: "linux", Architecture: "arm", Variant: "v7"}).ToJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var img ocispecs.Image
+	if err = json.Unmarshal(byt, &img); err != nil {
+		t.Fatal(err)
+	}
+
+	if img.OS != "linux" || img.Architecture != "arm" || img.Variant != "v7" {
+		t.Errorf("unexpected platform %s/%s/%s", img.OS, img.Architecture, img.Variant)
+	}
+
+	if config.Platform != nil {
+		t.Error("ForPlatform must not modify the config")
+	}
+}
@@ -0,0 +1,292 @@
+package image
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/platforms"
+	"github.com/containerd/containerd/reference/docker"
+	"github.com/containerd/containerd/remotes"
+	ocispecs "github.com/opencontainers/image-spec/specs-go/v1"
+	"go.codecomet.dev/alkali/builder/exporter"
+	"go.codecomet.dev/alkali/builder/registry"
+	"go.codecomet.dev/containers/digest"
+)
+
+// Buildkit attaches attestations to the index, as manifests referring to the platform manifest they describe
+const (
+	referenceTypeAnnotation = "vnd.docker.reference.type"
+	attestationManifestType = "attestation-manifest"
+)
+
+var (
+	errNoName       = errors.New("index has no name")
+	errRepository   = errors.New("index names must share the same repository")
+	errNoImage      = errors.New("exporter response has no image")
+	errMediaType    = errors.New("unsupported mediatype")
+	errPlatform     = errors.New("platform added more than once")
+	errAnnotation   = errors.New("invalid index annotation")
+	errEmptyIndex   = errors.New("no image added to the index")
+	errUnknownImage = errors.New("image not found in the repository of the index")
+)
+
+// Index assembles images built separately (typically one platform per node, pushed by digest) into a single
+// multi-platform image. Images must have been pushed to the repository of the index, as registries do not resolve
+// manifests across repositories.
+type Index struct {
+	// Names the index is pushed to (eg: `docker.io/org/app:1.0`). They must share the same repository.
+	Names []string
+	// Annotations must be of type index (set on the index), or manifest-descriptor (set on the descriptors of the
+	// matching platform manifests)
+	Annotations []*exporter.Annotation
+	Resolver    remotes.Resolver
+
+	manifests []ocispecs.Descriptor
+}
+
+func NewIndex(resolver remotes.Resolver, names ...string) *Index {
+	return &Index{
+		Names:       names,
+		Annotations: []*exporter.Annotation{},
+		Resolver:    resolver,
+		manifests:   []ocispecs.Descriptor{},
+	}
+}
+
+// Add adds the image from the exporter response of a build. Indexes (multi-platform images, or images with
+// attestations) are flattened, and the platform of single manifests is read from their config if unknown.
+func (o *Index) Add(ctx context.Context, resp *exporter.Response) error {
+	repository, err := o.repository()
+	if err != nil {
+		return err
+	}
+
+	var desc ocispecs.Descriptor
+
+	switch {
+	case resp.Descriptor != nil:
+		desc = *resp.Descriptor
+	case resp.Digest != "":
+		if _, desc, err = o.Resolver.Resolve(ctx, repository+"@"+resp.Digest.String()); err != nil {
+			return o.fetchError(repository, resp.Digest, err)
+		}
+	default:
+		return errNoImage
+	}
+
+	switch {
+	case images.IsIndexType(desc.MediaType):
+		var index ocispecs.Index
+		if err = o.fetchJSON(ctx, repository, desc, &index); err != nil {
+			return err
+		}
+
+		for _, manifest := range index.Manifests {
+			if err = o.add(manifest); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	case images.IsManifestType(desc.MediaType):
+		if desc.Platform == nil {
+			if desc.Platform, err = o.platform(ctx, repository, desc); err != nil {
+				return err
+			}
+		}
+
+		return o.add(desc)
+	}
+
+	return fmt.Errorf("%w %q for %s", errMediaType, desc.MediaType, desc.Digest)
+}
+
+func (o *Index) add(desc ocispecs.Descriptor) error {
+	for _, existing := range o.manifests {
+		if existing.Digest == desc.Digest {
+			return nil
+		}
+
+		if isAttestation(existing) || isAttestation(desc) || existing.Platform == nil || desc.Platform == nil {
+			continue
+		}
+
+		if id := platformID(*desc.Platform); id == platformID(*existing.Platform) {
+			return fmt.Errorf("%w: %s (%s and %s)", errPlatform, id, existing.Digest, desc.Digest)
+		}
+	}
+
+	o.manifests = append(o.manifests, desc)
+
+	return nil
+}
+
+// Manifests returns the descriptors added so far.
+func (o *Index) Manifests() []ocispecs.Descriptor {
+	return o.manifests
+}
+
+// Push pushes the index to every name, and returns its descriptor.
+func (o *Index) Push(ctx context.Context) (*ocispecs.Descriptor, error) {
+	if _, err := o.repository(); err != nil {
+		return nil, err
+	}
+
+	byt, err := o.ToJSON()
+	if err != nil {
+		return nil, err
+	}
+
+	desc := ocispecs.Descriptor{
+		MediaType: ocispecs.MediaTypeImageIndex,
+		Digest:    digest.FromBytes(byt),
+		Size:      int64(len(byt)),
+	}
+
+	for _, name := range o.Names {
+		named, err := docker.ParseNormalizedNamed(name)
+		if err != nil {
+			return nil, err
+		}
+
+		if err = registry.Push(ctx, o.Resolver, docker.TagNameOnly(named).String(), desc, byt); err != nil {
+			return nil, fmt.Errorf("could not push index to %s: %w", name, err)
+		}
+	}
+
+	return &desc, nil
+}
+
+// ToJSON renders the index, with annotations applied.
+func (o *Index) ToJSON() ([]byte, error) {
+	if len(o.manifests) == 0 {
+		return nil, errEmptyIndex
+	}
+
+	index := ocispecs.Index{
+		MediaType: ocispecs.MediaTypeImageIndex,
+		Manifests: append([]ocispecs.Descriptor{}, o.manifests...),
+	}
+	index.SchemaVersion = 2
+
+	for _, annotation := range o.Annotations {
+		switch annotation.Type {
+		case exporter.AnnotationIndex:
+			if index.Annotations == nil {
+				index.Annotations = map[string]string{}
+			}
+
+			index.Annotations[annotation.Key] = annotation.Value
+		case exporter.AnnotationManifestDescriptor:
+			if err := annotateDescriptors(index.Manifests, annotation); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("%w: type %q can not be set on an assembled index", errAnnotation, annotation.Type)
+		}
+	}
+
+	return json.Marshal(index)
+}
+
+func annotateDescriptors(manifests []ocispecs.Descriptor, annotation *exporter.Annotation) error {
+	var matcher platforms.Matcher
+
+	if annotation.Platform != "" {
+		plt, err := platforms.Parse(annotation.Platform)
+		if err != nil {
+			return fmt.Errorf("%w %s: %v", errAnnotation, annotation.Attr(), err) //nolint:errorlint
+		}
+
+		matcher = platforms.OnlyStrict(plt)
+	}
+
+	for idx, desc := range manifests {
+		if isAttestation(desc) || (matcher != nil && (desc.Platform == nil || !matcher.Match(*desc.Platform))) {
+			continue
+		}
+
+		// Descriptors come from fetched indexes and may share their annotations map
+		annotations := map[string]string{}
+		for k, v := range desc.Annotations {
+			annotations[k] = v
+		}
+
+		annotations[annotation.Key] = annotation.Value
+		manifests[idx].Annotations = annotations
+	}
+
+	return nil
+}
+
+func platformID(platform ocispecs.Platform) string {
+	return platforms.Format(platforms.Normalize(platform))
+}
+
+func isAttestation(desc ocispecs.Descriptor) bool {
+	return desc.Annotations[referenceTypeAnnotation] == attestationManifestType
+}
+
+// repository returns the repository shared by all names
+func (o *Index) repository() (string, error) {
+	if len(o.Names) == 0 {
+		return "", errNoName
+	}
+
+	repository := ""
+
+	for _, name := range o.Names {
+		named, err := docker.ParseNormalizedNamed(name)
+		if err != nil {
+			return "", err
+		}
+
+		if repository != "" && repository != named.Name() {
+			return "", fmt.Errorf("%w: %s and %s", errRepository, repository, named.Name())
+		}
+
+		repository = named.Name()
+	}
+
+	return repository, nil
+}
+
+// platform reads the platform of a manifest from its config
+func (o *Index) platform(ctx context.Context, repository string, desc ocispecs.Descriptor) (*ocispecs.Platform, error) {
+	var manifest ocispecs.Manifest
+	if err := o.fetchJSON(ctx, repository, desc, &manifest); err != nil {
+		return nil, err
+	}
+
+	var config ocispecs.Image
+	if err := o.fetchJSON(ctx, repository, manifest.Config, &config); err != nil {
+		return nil, err
+	}
+
+	return &ocispecs.Platform{
+		Architecture: config.Architecture,
+		OS:           config.OS,
+		OSVersion:    config.OSVersion,
+		OSFeatures:   config.OSFeatures,
+		Variant:      config.Variant,
+	}, nil
+}
+
+func (o *Index) fetchJSON(ctx context.Context, repository string, desc ocispecs.Descriptor, out any) error {
+	if err := registry.FetchJSON(ctx, o.Resolver, repository, desc, out); err != nil {
+		return o.fetchError(repository, desc.Digest, err)
+	}
+
+	return nil
+}
+
+func (o *Index) fetchError(repository string, dgst digest.Digest, err error) error {
+	if registry.IsNotFound(err) {
+		return fmt.Errorf("%w: %s@%s", errUnknownImage, repository, dgst)
+	}
+
+	return err
+}
@@ -0,0 +1,102 @@
+package image_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	ocispecs "github.com/opencontainers/image-spec/specs-go/v1"
+	"go.codecomet.dev/alkali/builder/exporter"
+	"go.codecomet.dev/alkali/builder/image"
+	"go.codecomet.dev/alkali/builder/internal/fakeregistry"
+	"go.codecomet.dev/alkali/builder/registry"
+	"go.codecomet.dev/containers/digest"
+)
+
+func marshal(t *testing.T, value any) []byte {
+	t.Helper()
+
+	byt, err := json.Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return byt
+}
+
+// pushImage pushes a single platform image by digest, and returns its digest
+func pushImage(t *testing.T, reg *fakeregistry.Registry, platform ocispecs.Platform) digest.Digest {
+	t.Helper()
+
+	config := marshal(t, ocispecs.Image{OS: platform.OS, Architecture: platform.Architecture, Variant: platform.Variant})
+
+	return reg.PutManifest("org/app", "", ocispecs.MediaTypeImageManifest, marshal(t, map[string]any{
+		"schemaVersion": 2,
+		"mediaType":     ocispecs.MediaTypeImageManifest,
+		"config": ocispecs.Descriptor{
+			MediaType: ocispecs.MediaTypeImageConfig,
+			Digest:    reg.PutBlob("org/app", config),
+			Size:      int64(len(config)),
+		},
+		"layers": []ocispecs.Descriptor{},
+	}))
+}
+
+func TestIndexPush(t *testing.T) {
+	t.Parallel()
+
+	reg, err := fakeregistry.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer reg.Close()
+
+	amd64 := pushImage(t, reg, ocispecs.Platform{OS: "linux", Architecture: "amd64"})
+	arm64 := pushImage(t, reg, ocispecs.Platform{OS: "linux", Architecture: "arm64"})
+
+	index := image.NewIndex(registry.New().Resolver(), reg.Host()+"/org/app:1.0", reg.Host()+"/org/app:latest")
+
+	for _, dgst := range []digest.Digest{amd64, arm64} {
+		if err = index.Add(context.Background(), &exporter.Response{Digest: dgst}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Same platform as an image already added, once normalized
+	if err = index.Add(context.Background(), &exporter.Response{
+		Digest: pushImage(t, reg, ocispecs.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}),
+	}); err == nil {
+		t.Error("expected a duplicate platform to be refused")
+	}
+
+	if err = index.Add(context.Background(), &exporter.Response{
+		Digest: digest.FromBytes([]byte("missing")),
+	}); err == nil {
+		t.Error("expected an image missing from the repository to be refused")
+	}
+
+	desc, err := index.Push(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tags := reg.Tags("org/app")
+	if tags["1.0"] != desc.Digest || tags["latest"] != desc.Digest {
+		t.Errorf("expected the index to be pushed to every tag, got %v", tags)
+	}
+
+	byt, _, ok := reg.Manifest("org/app", "1.0")
+	if !ok {
+		t.Fatal("index not found")
+	}
+
+	var pushed ocispecs.Index
+	if err = json.Unmarshal(byt, &pushed); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(pushed.Manifests) != 2 || pushed.Manifests[1].Platform.Architecture != "arm64" {
+		t.Errorf("unexpected manifests %+v", pushed.Manifests)
+	}
+}
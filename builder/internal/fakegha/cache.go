@@ -0,0 +1,286 @@
+// Package fakegha is an in-memory implementation of the GitHub Actions cache service, covering what the buildkit gha
+// cache backend requires (lookups by key prefix, reservations, chunked uploads and archive downloads). It is only meant
+// for tests.
+package fakegha
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	apiPrefix      = "/_apis/artifactcache/"
+	archivePrefix  = "/archives/"
+	readTimeout    = 30 * time.Second
+	tokenLifetime  = time.Hour
+	readWriteScope = 3
+)
+
+type entry struct {
+	id        int
+	key       string
+	version   string
+	data      []byte
+	committed bool
+}
+
+// Cache keeps entries in memory, in the order they were reserved.
+type Cache struct {
+	mu      sync.Mutex
+	entries []*entry
+
+	listener net.Listener
+	server   *http.Server
+}
+
+// New starts a cache service on a random port of the loopback interface.
+func New() (*Cache, error) {
+	return Listen("127.0.0.1:0")
+}
+
+// Listen starts a cache service on addr (eg: `0.0.0.0:0`, for it to be reachable from a buildkit daemon in a
+// container).
+func Listen(addr string) (*Cache, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	cache := &Cache{
+		entries:  []*entry{},
+		listener: listener,
+	}
+
+	cache.server = &http.Server{Handler: cache, ReadHeaderTimeout: readTimeout}
+
+	go func() {
+		_ = cache.server.Serve(listener)
+	}()
+
+	return cache, nil
+}
+
+// URL is the service url, as seen from host (the equivalent of $ACTIONS_CACHE_URL).
+func (o *Cache) URL(host string) string {
+	return "http://" + net.JoinHostPort(host, strconv.Itoa(o.Port())) + "/"
+}
+
+func (o *Cache) Port() int {
+	return o.listener.Addr().(*net.TCPAddr).Port //nolint:forcetypeassert
+}
+
+func (o *Cache) Close() error {
+	return o.server.Close()
+}
+
+// Token returns an unsigned token granting read and write access to scope (the equivalent of $ACTIONS_RUNTIME_TOKEN).
+// Clients do not verify signatures, but do read scopes and validity from the claims.
+func Token(scope string) string {
+	access, _ := json.Marshal([]map[string]any{{"Scope": scope, "Permission": readWriteScope}})
+	now := time.Now()
+
+	header, _ := json.Marshal(map[string]string{"alg": "HS256", "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]any{
+		"ac":  string(access),
+		"nbf": now.Add(-time.Minute).Unix(),
+		"exp": now.Add(tokenLifetime).Unix(),
+	})
+
+	encode := base64.RawURLEncoding.EncodeToString
+
+	return encode(header) + "." + encode(claims) + "." + encode([]byte("unsigned"))
+}
+
+// Keys returns the keys of committed entries.
+func (o *Cache) Keys() []string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	keys := []string{}
+
+	for _, ent := range o.entries {
+		if ent.committed {
+			keys = append(keys, ent.key)
+		}
+	}
+
+	return keys
+}
+
+func (o *Cache) ServeHTTP(writer http.ResponseWriter, req *http.Request) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if strings.HasPrefix(req.URL.Path, archivePrefix) {
+		o.archive(writer, req, strings.TrimPrefix(req.URL.Path, archivePrefix))
+
+		return
+	}
+
+	if !strings.HasPrefix(req.Header.Get("Authorization"), "Bearer ") {
+		fail(writer, http.StatusUnauthorized, "missing token", "Unauthorized")
+
+		return
+	}
+
+	path := strings.TrimPrefix(req.URL.Path, apiPrefix)
+
+	switch {
+	case path == "cache" && req.Method == http.MethodGet:
+		o.lookup(writer, req)
+	case path == "caches" && req.Method == http.MethodPost:
+		o.reserve(writer, req)
+	case strings.HasPrefix(path, "caches/") && req.Method == http.MethodPatch:
+		o.upload(writer, req, strings.TrimPrefix(path, "caches/"))
+	case strings.HasPrefix(path, "caches/") && req.Method == http.MethodPost:
+		o.commit(writer, req, strings.TrimPrefix(path, "caches/"))
+	default:
+		fail(writer, http.StatusNotFound, "not found", "NotFound")
+	}
+}
+
+// lookup returns the newest committed entry matching the first key that matches, keys matching by prefix
+func (o *Cache) lookup(writer http.ResponseWriter, req *http.Request) {
+	version := req.URL.Query().Get("version")
+
+	for _, key := range strings.Split(req.URL.Query().Get("keys"), ",") {
+		var found *entry
+
+		for _, ent := range o.entries {
+			if ent.committed && ent.version == version && strings.HasPrefix(ent.key, key) {
+				found = ent
+			}
+		}
+
+		if found != nil {
+			reply(writer, http.StatusOK, map[string]string{
+				"cacheKey":        found.key,
+				"scope":           "refs/heads/main",
+				"archiveLocation": "http://" + req.Host + archivePrefix + strconv.Itoa(found.id),
+			})
+
+			return
+		}
+	}
+
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+func (o *Cache) reserve(writer http.ResponseWriter, req *http.Request) {
+	var body struct {
+		Key     string `json:"key"`
+		Version string `json:"version"`
+	}
+
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil || body.Key == "" {
+		fail(writer, http.StatusBadRequest, "invalid reservation", "ArgumentException")
+
+		return
+	}
+
+	for _, ent := range o.entries {
+		if ent.key == body.Key && ent.version == body.Version {
+			fail(writer, http.StatusConflict, fmt.Sprintf("cache entry %s already exists", body.Key),
+				"ArtifactCacheEntryAlreadyExistsException")
+
+			return
+		}
+	}
+
+	ent := &entry{id: len(o.entries) + 1, key: body.Key, version: body.Version, data: []byte{}}
+	o.entries = append(o.entries, ent)
+
+	reply(writer, http.StatusCreated, map[string]int{"cacheID": ent.id})
+}
+
+func (o *Cache) upload(writer http.ResponseWriter, req *http.Request, id string) {
+	ent := o.find(id)
+	if ent == nil || ent.committed {
+		fail(writer, http.StatusNotFound, "unknown cache "+id, "NotFound")
+
+		return
+	}
+
+	var start, end int
+	if _, err := fmt.Sscanf(req.Header.Get("Content-Range"), "bytes %d-%d/*", &start, &end); err != nil {
+		fail(writer, http.StatusBadRequest, "invalid content range", "ArgumentException")
+
+		return
+	}
+
+	data, err := io.ReadAll(req.Body)
+	if err != nil || len(data) != end-start+1 {
+		fail(writer, http.StatusBadRequest, "content does not match range", "ArgumentException")
+
+		return
+	}
+
+	if grow := end + 1 - len(ent.data); grow > 0 {
+		ent.data = append(ent.data, make([]byte, grow)...)
+	}
+
+	copy(ent.data[start:], data)
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+func (o *Cache) commit(writer http.ResponseWriter, req *http.Request, id string) {
+	ent := o.find(id)
+	if ent == nil || ent.committed {
+		fail(writer, http.StatusNotFound, "unknown cache "+id, "NotFound")
+
+		return
+	}
+
+	var body struct {
+		Size int `json:"size"`
+	}
+
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil || body.Size != len(ent.data) {
+		fail(writer, http.StatusBadRequest, "size does not match uploaded content", "ArgumentException")
+
+		return
+	}
+
+	ent.committed = true
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+func (o *Cache) archive(writer http.ResponseWriter, req *http.Request, id string) {
+	ent := o.find(id)
+	if ent == nil || !ent.committed {
+		http.NotFound(writer, req)
+
+		return
+	}
+
+	http.ServeContent(writer, req, "", time.Time{}, bytes.NewReader(ent.data))
+}
+
+func (o *Cache) find(id string) *entry {
+	for _, ent := range o.entries {
+		if strconv.Itoa(ent.id) == id {
+			return ent
+		}
+	}
+
+	return nil
+}
+
+func reply(writer http.ResponseWriter, status int, body any) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+	_ = json.NewEncoder(writer).Encode(body)
+}
+
+func fail(writer http.ResponseWriter, status int, message string, typeKey string) {
+	reply(writer, status, map[string]any{"message": message, "typeKey": typeKey})
+}
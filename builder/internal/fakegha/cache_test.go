@@ -0,0 +1,98 @@
+package fakegha_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+
+	"go.codecomet.dev/alkali/builder/internal/fakegha"
+)
+
+func TestRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	service, err := fakegha.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer service.Close()
+
+	api := service.URL("localhost") + "_apis/artifactcache/"
+	token := fakegha.Token("refs/heads/main")
+
+	call := func(method string, url string, body string, headers map[string]string) (int, []byte) {
+		t.Helper()
+
+		req, err := http.NewRequest(method, url, bytes.NewBufferString(body)) //nolint:noctx
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer resp.Body.Close()
+
+		data, _ := io.ReadAll(resp.Body)
+
+		return resp.StatusCode, data
+	}
+
+	if status, _ := call(http.MethodGet, api+"cache?keys=index%23&version=v1", "", nil); status != http.StatusNoContent {
+		t.Fatalf("expected no entry, got %d", status)
+	}
+
+	status, data := call(http.MethodPost, api+"caches", `{"key":"index#1","version":"v1"}`, nil)
+	if status != http.StatusCreated {
+		t.Fatalf("reservation failed: %d %s", status, data)
+	}
+
+	var reserved struct {
+		CacheID int `json:"cacheID"`
+	}
+
+	if err = json.Unmarshal(data, &reserved); err != nil || reserved.CacheID == 0 {
+		t.Fatalf("unexpected reservation %s", data)
+	}
+
+	if status, _ = call(http.MethodPost, api+"caches", `{"key":"index#1","version":"v1"}`, nil); status != http.StatusConflict {
+		t.Errorf("expected a conflict reserving twice, got %d", status)
+	}
+
+	cacheURL := api + "caches/" + "1"
+	call(http.MethodPatch, cacheURL, "world", map[string]string{"Content-Range": "bytes 6-10/*"})
+	call(http.MethodPatch, cacheURL, "hello ", map[string]string{"Content-Range": "bytes 0-5/*"})
+
+	if status, data = call(http.MethodPost, cacheURL, `{"size":11}`, nil); status != http.StatusNoContent {
+		t.Fatalf("commit failed: %d %s", status, data)
+	}
+
+	status, data = call(http.MethodGet, api+"cache?keys=other,index%23&version=v1", "", nil)
+	if status != http.StatusOK {
+		t.Fatalf("expected an entry, got %d", status)
+	}
+
+	var found struct {
+		Key      string `json:"cacheKey"`
+		Location string `json:"archiveLocation"`
+	}
+
+	if err = json.Unmarshal(data, &found); err != nil || found.Key != "index#1" {
+		t.Fatalf("unexpected entry %s", data)
+	}
+
+	if _, data = call(http.MethodGet, found.Location, "", nil); string(data) != "hello world" {
+		t.Errorf("unexpected archive %q", data)
+	}
+}
@@ -0,0 +1,334 @@
+// Package fakeregistry is an in-memory implementation of the registry API, covering what pushing and pulling images
+// and cache requires (blob uploads and mounts, manifests by tag and digest). It is only meant for tests.
+package fakeregistry
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.codecomet.dev/containers/digest"
+)
+
+const (
+	apiPrefix       = "/v2/"
+	blobsPath       = "/blobs/"
+	uploadsPath     = "/blobs/uploads/"
+	manifestsPath   = "/manifests/"
+	readTimeout     = 30 * time.Second
+	contentTypeJSON = "application/json"
+)
+
+type manifest struct {
+	mediaType string
+	data      []byte
+}
+
+// Registry stores blobs and manifests per repository, so that references across repositories fail as they would on a
+// real registry.
+type Registry struct {
+	mu        sync.Mutex
+	blobs     map[string]map[digest.Digest][]byte
+	manifests map[string]map[digest.Digest]*manifest
+	tags      map[string]map[string]digest.Digest
+	uploads   map[string]*bytes.Buffer
+	nextID    int
+
+	listener net.Listener
+	server   *http.Server
+}
+
+// New starts a registry on a random port of the loopback interface.
+func New() (*Registry, error) {
+	return Listen("127.0.0.1:0")
+}
+
+// Listen starts a registry on addr (eg: `0.0.0.0:0`, for it to be reachable from a buildkit daemon in a container).
+func Listen(addr string) (*Registry, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	reg := &Registry{
+		blobs:     map[string]map[digest.Digest][]byte{},
+		manifests: map[string]map[digest.Digest]*manifest{},
+		tags:      map[string]map[string]digest.Digest{},
+		uploads:   map[string]*bytes.Buffer{},
+		listener:  listener,
+	}
+
+	reg.server = &http.Server{Handler: reg, ReadHeaderTimeout: readTimeout}
+
+	go func() {
+		_ = reg.server.Serve(listener)
+	}()
+
+	return reg, nil
+}
+
+// Host is the address of the registry, as `localhost:<port>` so that clients use plain HTTP.
+func (o *Registry) Host() string {
+	return "localhost:" + strconv.Itoa(o.Port())
+}
+
+func (o *Registry) Port() int {
+	return o.listener.Addr().(*net.TCPAddr).Port //nolint:forcetypeassert
+}
+
+func (o *Registry) Close() error {
+	return o.server.Close()
+}
+
+// Manifest returns the manifest a tag or digest points to, in a repository.
+func (o *Registry) Manifest(repository string, reference string) ([]byte, string, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	man := o.resolve(repository, reference)
+	if man == nil {
+		return nil, "", false
+	}
+
+	return man.data, man.mediaType, true
+}
+
+// Tags returns the tags of a repository, and the digests they point to.
+func (o *Registry) Tags(repository string) map[string]digest.Digest {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	ret := map[string]digest.Digest{}
+	for tag, dgst := range o.tags[repository] {
+		ret[tag] = dgst
+	}
+
+	return ret
+}
+
+// PutBlob stores a blob directly (eg: to seed an image).
+func (o *Registry) PutBlob(repository string, data []byte) digest.Digest {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return o.putBlob(repository, data)
+}
+
+// PutManifest stores a manifest directly, and tags it if tag is not empty.
+func (o *Registry) PutManifest(repository string, tag string, mediaType string, data []byte) digest.Digest {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return o.putManifest(repository, tag, mediaType, data)
+}
+
+func (o *Registry) ServeHTTP(writer http.ResponseWriter, req *http.Request) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if req.URL.Path == apiPrefix {
+		writer.WriteHeader(http.StatusOK)
+
+		return
+	}
+
+	path := strings.TrimPrefix(req.URL.Path, apiPrefix)
+
+	switch {
+	case strings.Contains(path, uploadsPath):
+		idx := strings.LastIndex(path, uploadsPath)
+		o.upload(writer, req, path[:idx], path[idx+len(uploadsPath):])
+	case strings.HasSuffix(path, strings.TrimSuffix(uploadsPath, "/")):
+		o.upload(writer, req, strings.TrimSuffix(path, strings.TrimSuffix(uploadsPath, "/")), "")
+	case strings.Contains(path, manifestsPath):
+		idx := strings.LastIndex(path, manifestsPath)
+		o.manifest(writer, req, path[:idx], path[idx+len(manifestsPath):])
+	case strings.Contains(path, blobsPath):
+		idx := strings.LastIndex(path, blobsPath)
+		o.blob(writer, req, path[:idx], digest.Digest(path[idx+len(blobsPath):]))
+	default:
+		fail(writer, http.StatusNotFound, "NAME_UNKNOWN")
+	}
+}
+
+func (o *Registry) blob(writer http.ResponseWriter, req *http.Request, repository string, dgst digest.Digest) {
+	data, ok := o.blobs[repository][dgst]
+	if !ok {
+		fail(writer, http.StatusNotFound, "BLOB_UNKNOWN")
+
+		return
+	}
+
+	writer.Header().Set("Docker-Content-Digest", dgst.String())
+	writer.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeContent(writer, req, "", time.Time{}, bytes.NewReader(data))
+}
+
+func (o *Registry) upload(writer http.ResponseWriter, req *http.Request, repository string, id string) {
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		fail(writer, http.StatusBadRequest, "BLOB_UPLOAD_INVALID")
+
+		return
+	}
+
+	dgst := digest.Digest(req.URL.Query().Get("digest"))
+
+	switch {
+	case req.Method == http.MethodPost && id == "":
+		if mount := digest.Digest(req.URL.Query().Get("mount")); mount != "" {
+			if data, ok := o.blobs[req.URL.Query().Get("from")][mount]; ok {
+				o.putBlob(repository, data)
+				o.created(writer, repository, mount)
+
+				return
+			}
+		}
+
+		// Monolithic upload
+		if dgst != "" {
+			o.finish(writer, repository, dgst, body)
+
+			return
+		}
+
+		o.nextID++
+		id = strconv.Itoa(o.nextID)
+		o.uploads[id] = bytes.NewBuffer(body)
+		o.accepted(writer, repository, id)
+	case req.Method == http.MethodPatch && o.uploads[id] != nil:
+		o.uploads[id].Write(body)
+		o.accepted(writer, repository, id)
+	case req.Method == http.MethodPut && o.uploads[id] != nil:
+		data := append(o.uploads[id].Bytes(), body...)
+		delete(o.uploads, id)
+		o.finish(writer, repository, dgst, data)
+	default:
+		fail(writer, http.StatusNotFound, "BLOB_UPLOAD_UNKNOWN")
+	}
+}
+
+func (o *Registry) accepted(writer http.ResponseWriter, repository string, id string) {
+	writer.Header().Set("Location", apiPrefix+repository+uploadsPath+id)
+	writer.Header().Set("Docker-Upload-UUID", id)
+	if size := o.uploads[id].Len(); size > 0 {
+		writer.Header().Set("Range", fmt.Sprintf("0-%d", size-1))
+	}
+
+	writer.WriteHeader(http.StatusAccepted)
+}
+
+func (o *Registry) finish(writer http.ResponseWriter, repository string, dgst digest.Digest, data []byte) {
+	if digest.FromBytes(data) != dgst {
+		fail(writer, http.StatusBadRequest, "DIGEST_INVALID")
+
+		return
+	}
+
+	o.putBlob(repository, data)
+	o.created(writer, repository, dgst)
+}
+
+func (o *Registry) created(writer http.ResponseWriter, repository string, dgst digest.Digest) {
+	writer.Header().Set("Location", apiPrefix+repository+blobsPath+dgst.String())
+	writer.Header().Set("Docker-Content-Digest", dgst.String())
+	writer.WriteHeader(http.StatusCreated)
+}
+
+func (o *Registry) manifest(writer http.ResponseWriter, req *http.Request, repository string, reference string) {
+	if req.Method == http.MethodPut {
+		data, err := io.ReadAll(req.Body)
+		if err != nil {
+			fail(writer, http.StatusBadRequest, "MANIFEST_INVALID")
+
+			return
+		}
+
+		tag := reference
+		if dgst := digest.Digest(reference); dgst.Validate() == nil {
+			if digest.FromBytes(data) != dgst {
+				fail(writer, http.StatusBadRequest, "DIGEST_INVALID")
+
+				return
+			}
+
+			tag = ""
+		}
+
+		dgst := o.putManifest(repository, tag, req.Header.Get("Content-Type"), data)
+		writer.Header().Set("Location", apiPrefix+repository+manifestsPath+dgst.String())
+		writer.Header().Set("Docker-Content-Digest", dgst.String())
+		writer.WriteHeader(http.StatusCreated)
+
+		return
+	}
+
+	man := o.resolve(repository, reference)
+	if man == nil {
+		fail(writer, http.StatusNotFound, "MANIFEST_UNKNOWN")
+
+		return
+	}
+
+	writer.Header().Set("Content-Type", man.mediaType)
+	writer.Header().Set("Docker-Content-Digest", digest.FromBytes(man.data).String())
+	writer.Header().Set("Content-Length", strconv.Itoa(len(man.data)))
+	writer.WriteHeader(http.StatusOK)
+
+	if req.Method == http.MethodGet {
+		_, _ = writer.Write(man.data)
+	}
+}
+
+func (o *Registry) resolve(repository string, reference string) *manifest {
+	dgst := digest.Digest(reference)
+	if dgst.Validate() != nil {
+		dgst = o.tags[repository][reference]
+	}
+
+	return o.manifests[repository][dgst]
+}
+
+func (o *Registry) putBlob(repository string, data []byte) digest.Digest {
+	dgst := digest.FromBytes(data)
+
+	if o.blobs[repository] == nil {
+		o.blobs[repository] = map[digest.Digest][]byte{}
+	}
+
+	o.blobs[repository][dgst] = data
+
+	return dgst
+}
+
+func (o *Registry) putManifest(repository string, tag string, mediaType string, data []byte) digest.Digest {
+	dgst := digest.FromBytes(data)
+
+	if o.manifests[repository] == nil {
+		o.manifests[repository] = map[digest.Digest]*manifest{}
+		o.tags[repository] = map[string]digest.Digest{}
+	}
+
+	o.manifests[repository][dgst] = &manifest{mediaType: mediaType, data: data}
+
+	if tag != "" {
+		o.tags[repository][tag] = dgst
+	}
+
+	return dgst
+}
+
+func fail(writer http.ResponseWriter, status int, code string) {
+	writer.Header().Set("Content-Type", contentTypeJSON)
+	writer.WriteHeader(status)
+	_ = json.NewEncoder(writer).Encode(map[string]any{
+		"errors": []map[string]string{{"code": code, "message": strings.ToLower(strings.ReplaceAll(code, "_", " "))}},
+	})
+}
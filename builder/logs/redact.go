@@ -0,0 +1,223 @@
+package logs
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/moby/buildkit/client"
+	"github.com/moby/buildkit/util/progress/progresswriter"
+	"go.codecomet.dev/containers/digest"
+)
+
+const redactedMarker = "*****"
+
+type streamKey struct {
+	vertex digest.Digest
+	stream Stream
+}
+
+// Redactor scrubs known secret values from vertex logs.
+// Since a secret may be split across log chunks, trailing bytes that could be the beginning of a secret are held back
+// until the next chunk for the same vertex and stream arrives, or until Flush is called.
+type Redactor struct {
+	secrets [][]byte
+	pending map[streamKey][]byte
+}
+
+func NewRedactor(secrets ...string) *Redactor {
+	seen := map[string]bool{}
+	red := &Redactor{
+		secrets: [][]byte{},
+		pending: map[streamKey][]byte{},
+	}
+
+	for _, secret := range secrets {
+		if secret == "" || seen[secret] {
+			continue
+		}
+
+		seen[secret] = true
+		red.secrets = append(red.secrets, []byte(secret))
+	}
+
+	// Longest first, so that a secret containing another one gets fully redacted
+	sort.SliceStable(red.secrets, func(i, j int) bool {
+		return len(red.secrets[i]) > len(red.secrets[j])
+	})
+
+	return red
+}
+
+// Redact returns a copy of the status with secrets removed from the logs, and from vertex names, errors and warnings.
+// The original status is left untouched.
+func (o *Redactor) Redact(status *client.SolveStatus) *client.SolveStatus {
+	if len(o.secrets) == 0 {
+		return status
+	}
+
+	ret := *status
+	ret.Vertexes = make([]*client.Vertex, 0, len(status.Vertexes))
+	ret.Statuses = make([]*client.VertexStatus, 0, len(status.Statuses))
+	ret.Warnings = make([]*client.VertexWarning, 0, len(status.Warnings))
+	ret.Logs = make([]*client.VertexLog, 0, len(status.Logs))
+
+	for _, vtx := range status.Vertexes {
+		cp := *vtx
+		cp.Name = o.replace(vtx.Name)
+		cp.Error = o.replace(vtx.Error)
+		ret.Vertexes = append(ret.Vertexes, &cp)
+	}
+
+	for _, vst := range status.Statuses {
+		cp := *vst
+		cp.ID = o.replace(vst.ID)
+		cp.Name = o.replace(vst.Name)
+		ret.Statuses = append(ret.Statuses, &cp)
+	}
+
+	for _, vwarn := range status.Warnings {
+		cp := *vwarn
+		cp.Short = []byte(o.replace(string(vwarn.Short)))
+		cp.URL = o.replace(vwarn.URL)
+		cp.Detail = make([][]byte, 0, len(vwarn.Detail))
+
+		for _, detail := range vwarn.Detail {
+			cp.Detail = append(cp.Detail, []byte(o.replace(string(detail))))
+		}
+
+		ret.Warnings = append(ret.Warnings, &cp)
+	}
+
+	for _, vlog := range status.Logs {
+		key := streamKey{vertex: vlog.Vertex, stream: Stream(vlog.Stream)}
+
+		out, rest := o.scrub(append(o.pending[key], vlog.Data...))
+		if len(rest) > 0 {
+			o.pending[key] = rest
+		} else {
+			delete(o.pending, key)
+		}
+
+		if len(out) == 0 {
+			continue
+		}
+
+		cp := *vlog
+		cp.Data = out
+		ret.Logs = append(ret.Logs, &cp)
+	}
+
+	return &ret
+}
+
+// Flush returns whatever has been held back so far, or nil if there is nothing left.
+func (o *Redactor) Flush() *client.SolveStatus {
+	if len(o.pending) == 0 {
+		return nil
+	}
+
+	ret := &client.SolveStatus{}
+	now := time.Now()
+
+	for key, data := range o.pending {
+		ret.Logs = append(ret.Logs, &client.VertexLog{
+			Vertex:    key.vertex,
+			Stream:    int(key.stream),
+			Data:      data,
+			Timestamp: now,
+		})
+	}
+
+	o.pending = map[streamKey][]byte{}
+
+	return ret
+}
+
+// replace redacts complete strings, which, contrary to logs, are never split
+func (o *Redactor) replace(value string) string {
+	for _, secret := range o.secrets {
+		value = strings.ReplaceAll(value, string(secret), redactedMarker)
+	}
+
+	return value
+}
+
+func (o *Redactor) scrub(buf []byte) ([]byte, []byte) {
+	out := make([]byte, 0, len(buf))
+	idx := 0
+
+	for idx < len(buf) {
+		if secret := o.match(buf[idx:]); secret != nil {
+			out = append(out, redactedMarker...)
+			idx += len(secret)
+
+			continue
+		}
+
+		// What is left could be the start of a secret: hold it back
+		if o.partial(buf[idx:]) {
+			break
+		}
+
+		out = append(out, buf[idx])
+		idx++
+	}
+
+	return out, append([]byte(nil), buf[idx:]...)
+}
+
+func (o *Redactor) match(buf []byte) []byte {
+	for _, secret := range o.secrets {
+		if bytes.HasPrefix(buf, secret) {
+			return secret
+		}
+	}
+
+	return nil
+}
+
+func (o *Redactor) partial(buf []byte) bool {
+	for _, secret := range o.secrets {
+		if len(buf) < len(secret) && bytes.HasPrefix(secret, buf) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Redact wraps a progress writer so that everything reaching it (display, traces) has been scrubbed of secrets.
+func Redact(in progresswriter.Writer, redactor *Redactor) progresswriter.Writer {
+	writer := &redactWriter{Writer: in, status: make(chan *client.SolveStatus)}
+
+	go func() {
+		defer close(in.Status())
+
+		for status := range writer.status {
+			select {
+			case in.Status() <- redactor.Redact(status):
+			case <-in.Done():
+			}
+		}
+
+		if status := redactor.Flush(); status != nil {
+			select {
+			case in.Status() <- status:
+			case <-in.Done():
+			}
+		}
+	}()
+
+	return writer
+}
+
+type redactWriter struct {
+	progresswriter.Writer
+	status chan *client.SolveStatus
+}
+
+func (o *redactWriter) Status() chan *client.SolveStatus {
+	return o.status
+}
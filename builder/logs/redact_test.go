@@ -0,0 +1,52 @@
+package logs_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/moby/buildkit/client"
+	"go.codecomet.dev/alkali/builder/logs"
+	"go.codecomet.dev/containers/digest"
+)
+
+func TestRedactVertexes(t *testing.T) {
+	t.Parallel()
+
+	secret := "s3cr3t-t0ken"
+	dgst := digest.FromBytes([]byte("vertex"))
+	status := &client.SolveStatus{
+		Vertexes: []*client.Vertex{{
+			Digest: dgst,
+			Name:   "RUN curl -H 'Authorization: " + secret + "' https://example.com",
+			Error:  "exit code 1: " + secret,
+		}},
+		Statuses: []*client.VertexStatus{{ID: "pulling " + secret, Vertex: dgst}},
+		Warnings: []*client.VertexWarning{{
+			Vertex: dgst,
+			Short:  []byte("token " + secret),
+			Detail: [][]byte{[]byte(secret)},
+		}},
+		Logs: []*client.VertexLog{{Vertex: dgst, Stream: 1, Data: []byte("token=" + secret + "\n")}},
+	}
+
+	redacted := logs.NewRedactor(secret).Redact(status)
+
+	values := []string{
+		redacted.Vertexes[0].Name,
+		redacted.Vertexes[0].Error,
+		redacted.Statuses[0].ID,
+		string(redacted.Warnings[0].Short),
+		string(redacted.Warnings[0].Detail[0]),
+		string(redacted.Logs[0].Data),
+	}
+
+	for _, value := range values {
+		if strings.Contains(value, secret) {
+			t.Errorf("secret not redacted from %q", value)
+		}
+	}
+
+	if !strings.Contains(status.Vertexes[0].Name, secret) {
+		t.Error("original status was modified")
+	}
+}
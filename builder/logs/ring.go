@@ -0,0 +1,47 @@
+package logs
+
+// ring keeps the most recent bytes written to it, up to capacity, and hands the oldest ones to spill when full.
+// The backing slice only grows as needed, so that short logs do not cost the full capacity.
+type ring struct {
+	capacity int
+	buf      []byte
+	start    int
+}
+
+func (o *ring) write(data []byte, spill func([]byte) error) error {
+	// Not full yet, just grow
+	if room := o.capacity - len(o.buf); room > 0 {
+		n := room
+		if len(data) < n {
+			n = len(data)
+		}
+
+		o.buf = append(o.buf, data[:n]...)
+		data = data[n:]
+	}
+
+	// Full: overwrite the oldest bytes, spilling them first
+	for len(data) > 0 {
+		n := o.capacity - o.start
+		if len(data) < n {
+			n = len(data)
+		}
+
+		if err := spill(o.buf[o.start : o.start+n]); err != nil {
+			return err
+		}
+
+		copy(o.buf[o.start:], data[:n])
+		o.start = (o.start + n) % o.capacity
+		data = data[n:]
+	}
+
+	return nil
+}
+
+func (o *ring) bytes() []byte {
+	ret := make([]byte, 0, len(o.buf))
+	ret = append(ret, o.buf[o.start:]...)
+
+	return append(ret, o.buf[:o.start]...)
+}
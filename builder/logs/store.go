@@ -0,0 +1,233 @@
+package logs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/moby/buildkit/client"
+	"go.codecomet.dev/containers/digest"
+	"go.codecomet.dev/core/filesystem"
+)
+
+type Stream int
+
+const (
+	Stdout Stream = 1
+	Stderr Stream = 2
+)
+
+// DefaultMemoryLimit is how much of each vertex stream is kept in memory before older output spills to disk.
+const DefaultMemoryLimit = 256 * 1024
+
+const spillPermissions = 0o600
+
+var errNoSuchLog = errors.New("no log recorded for this vertex and stream")
+
+type streamLog struct {
+	mem   ring
+	spill *os.File
+}
+
+// Store keeps logs demultiplexed per vertex and stream.
+// Only the tail of each stream is held in memory - anything older is spilled to a file, under the directory passed to
+// NewStore (or a temporary directory if left empty).
+type Store struct {
+	mu          sync.Mutex
+	dir         string
+	ownDir      bool
+	memoryLimit int
+	streams     map[streamKey]*streamLog
+	vertexes    []digest.Digest
+}
+
+func NewStore(dir string, memoryLimit int) *Store {
+	if memoryLimit <= 0 {
+		memoryLimit = DefaultMemoryLimit
+	}
+
+	return &Store{
+		dir:         dir,
+		memoryLimit: memoryLimit,
+		streams:     map[streamKey]*streamLog{},
+		vertexes:    []digest.Digest{},
+	}
+}
+
+// Add records all logs carried by a status.
+func (o *Store) Add(status *client.SolveStatus) error {
+	for _, vlog := range status.Logs {
+		if err := o.Write(vlog); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (o *Store) Write(vlog *client.VertexLog) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	key := streamKey{vertex: vlog.Vertex, stream: Stream(vlog.Stream)}
+
+	entry, ok := o.streams[key]
+	if !ok {
+		if !o.hasVertex(vlog.Vertex) {
+			o.vertexes = append(o.vertexes, vlog.Vertex)
+		}
+
+		entry = &streamLog{mem: ring{capacity: o.memoryLimit}}
+		o.streams[key] = entry
+	}
+
+	return entry.mem.write(vlog.Data, func(data []byte) error {
+		if entry.spill == nil {
+			file, err := o.create(key)
+			if err != nil {
+				return err
+			}
+
+			entry.spill = file
+		}
+
+		_, err := entry.spill.Write(data)
+
+		return err
+	})
+}
+
+// Vertexes returns the digests of all vertexes that produced output, in order of first output.
+func (o *Store) Vertexes() []digest.Digest {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return append([]digest.Digest{}, o.vertexes...)
+}
+
+// Streams returns which streams a vertex wrote to.
+func (o *Store) Streams(vtx digest.Digest) []Stream {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	ret := []Stream{}
+
+	for _, stream := range []Stream{Stdout, Stderr} {
+		if _, ok := o.streams[streamKey{vertex: vtx, stream: stream}]; ok {
+			ret = append(ret, stream)
+		}
+	}
+
+	return ret
+}
+
+// Get returns the complete output of a vertex for the given stream.
+func (o *Store) Get(vtx digest.Digest, stream Stream) ([]byte, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	entry, ok := o.streams[streamKey{vertex: vtx, stream: stream}]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s (%d)", errNoSuchLog, vtx, stream)
+	}
+
+	if entry.spill == nil {
+		return entry.mem.bytes(), nil
+	}
+
+	spilled, err := os.ReadFile(entry.spill.Name())
+	if err != nil {
+		return nil, err
+	}
+
+	return append(spilled, entry.mem.bytes()...), nil
+}
+
+// Copy writes the complete output of a vertex for the given stream to writer, without loading spilled data in memory.
+func (o *Store) Copy(writer io.Writer, vtx digest.Digest, stream Stream) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	entry, ok := o.streams[streamKey{vertex: vtx, stream: stream}]
+	if !ok {
+		return fmt.Errorf("%w: %s (%d)", errNoSuchLog, vtx, stream)
+	}
+
+	if entry.spill != nil {
+		file, err := os.Open(entry.spill.Name())
+		if err != nil {
+			return err
+		}
+
+		defer file.Close()
+
+		if _, err = io.Copy(writer, file); err != nil {
+			return err
+		}
+	}
+
+	_, err := writer.Write(entry.mem.bytes())
+
+	return err
+}
+
+// Close releases spill files. If the store created its own temporary directory, it is removed.
+func (o *Store) Close() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	var err error
+
+	for _, entry := range o.streams {
+		if entry.spill != nil {
+			if e := entry.spill.Close(); e != nil && err == nil {
+				err = e
+			}
+		}
+	}
+
+	if o.ownDir {
+		if e := os.RemoveAll(o.dir); e != nil && err == nil {
+			err = e
+		}
+
+		o.dir = ""
+		o.ownDir = false
+	}
+
+	o.streams = map[streamKey]*streamLog{}
+	o.vertexes = []digest.Digest{}
+
+	return err
+}
+
+func (o *Store) hasVertex(vtx digest.Digest) bool {
+	for _, v := range o.vertexes {
+		if v == vtx {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (o *Store) create(key streamKey) (*os.File, error) {
+	if o.dir == "" {
+		dir, err := os.MkdirTemp("", "alkali-logs-")
+		if err != nil {
+			return nil, err
+		}
+
+		o.dir = dir
+		o.ownDir = true
+	} else if err := os.MkdirAll(o.dir, filesystem.DirPermissionsDefault); err != nil {
+		return nil, err
+	}
+
+	name := filepath.Join(o.dir, fmt.Sprintf("%s-%d.log", key.vertex.Encoded(), key.stream))
+
+	return os.OpenFile(name, os.O_CREATE|os.O_TRUNC|os.O_RDWR, spillPermissions)
+}
@@ -0,0 +1,97 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/remotes"
+	ocispecs "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+var errNotFound = errors.New("not found in registry")
+
+// Fetch reads the content desc describes from a repository (eg: `docker.io/library/alpine`).
+func Fetch(ctx context.Context, resolver remotes.Resolver, repository string, desc ocispecs.Descriptor) ([]byte, error) {
+	fetcher, err := resolver.Fetcher(ctx, repository)
+	if err != nil {
+		return nil, err
+	}
+
+	reader, err := fetcher.Fetch(ctx, desc)
+	if errdefs.IsNotFound(err) {
+		return nil, fmt.Errorf("%w: %s@%s", errNotFound, repository, desc.Digest)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer reader.Close()
+
+	return io.ReadAll(reader)
+}
+
+// FetchJSON reads and unmarshals the content desc describes (manifests, indexes and configs).
+func FetchJSON(ctx context.Context, resolver remotes.Resolver, repository string, desc ocispecs.Descriptor, out any) error {
+	byt, err := Fetch(ctx, resolver, repository, desc)
+	if err != nil {
+		return err
+	}
+
+	if err = json.Unmarshal(byt, out); err != nil {
+		return fmt.Errorf("could not unmarshal %s: %w", desc.Digest, err)
+	}
+
+	return nil
+}
+
+// IsNotFound tells whether err is about missing content.
+func IsNotFound(err error) bool {
+	return errors.Is(err, errNotFound) || errdefs.IsNotFound(err)
+}
+
+// Push pushes data to ref, either a tag (eg: `docker.io/org/app:1.0`) or a digest. Content the registry already has is
+// not pushed again.
+func Push(ctx context.Context, resolver remotes.Resolver, ref string, desc ocispecs.Descriptor, data []byte) error {
+	// The resolver tracks pushes by descriptor: without a distinct ref name, pushing the same content to several tags
+	// would only push the first one
+	tagged := desc
+	tagged.Annotations = map[string]string{ocispecs.AnnotationRefName: ref}
+
+	for k, v := range desc.Annotations {
+		if k != ocispecs.AnnotationRefName {
+			tagged.Annotations[k] = v
+		}
+	}
+
+	pusher, err := resolver.Pusher(ctx, ref)
+	if err != nil {
+		return err
+	}
+
+	writer, err := pusher.Push(ctx, tagged)
+	if errdefs.IsAlreadyExists(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	defer writer.Close()
+
+	if _, err = writer.Write(data); err != nil {
+		return err
+	}
+
+	err = writer.Commit(ctx, desc.Size, desc.Digest)
+	if errdefs.IsAlreadyExists(err) {
+		return nil
+	}
+
+	return err
+}
@@ -3,6 +3,8 @@ package registry
 import (
 	"os"
 
+	"github.com/containerd/containerd/remotes"
+	"github.com/containerd/containerd/remotes/docker"
 	"github.com/docker/cli/cli/config"
 	"github.com/docker/cli/cli/config/configfile"
 	"github.com/docker/cli/cli/config/types"
@@ -12,6 +14,11 @@ import (
 
 // TODO figure out how to get mTLS for registries.
 
+const (
+	dockerHubHost     = "registry-1.docker.io"
+	dockerHubAuthHost = "https://index.docker.io/v1/"
+)
+
 func New() *Authenticator {
 	return &Authenticator{
 		dckr: config.LoadDefaultConfigFile(os.Stderr), // &configfile.ConfigFile{},
@@ -38,6 +45,52 @@ func (o *Authenticator) Login(auth *Credentials) {
 	}
 }
 
+// GetSecretValues returns all passwords and tokens known to the authenticator, so that they can be scrubbed from logs.
+func (o *Authenticator) GetSecretValues() []string {
+	values := []string{}
+
+	for _, auth := range o.dckr.AuthConfigs {
+		for _, value := range []string{auth.Password, auth.Auth, auth.IdentityToken, auth.RegistryToken} {
+			if value != "" {
+				values = append(values, value)
+			}
+		}
+	}
+
+	return values
+}
+
+// Resolver returns a resolver using the same credentials as the build session, for pushing and pulling from the client
+// side (eg: signatures, or manifest lists). Localhost registries are reached over plain HTTP.
+func (o *Authenticator) Resolver() remotes.Resolver {
+	authorizer := docker.NewDockerAuthorizer(docker.WithAuthCreds(o.credentials))
+
+	return docker.NewResolver(docker.ResolverOptions{
+		Hosts: docker.ConfigureDefaultRegistries(
+			docker.WithAuthorizer(authorizer),
+			docker.WithPlainHTTP(docker.MatchLocalhost),
+		),
+	})
+}
+
+func (o *Authenticator) credentials(host string) (string, string, error) {
+	// Same as buildkit
+	if host == dockerHubHost {
+		host = dockerHubAuthHost
+	}
+
+	auth, err := o.dckr.GetAuthConfig(host)
+	if err != nil {
+		return "", "", err
+	}
+
+	if auth.IdentityToken != "" {
+		return "", auth.IdentityToken, nil
+	}
+
+	return auth.Username, auth.Password, nil
+}
+
 func (o *Authenticator) GetAttachable() []session.Attachable {
 	return []session.Attachable{
 		authprovider.NewDockerAuthProvider(o.dckr),
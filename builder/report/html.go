@@ -0,0 +1,321 @@
+package report
+
+import (
+	_ "embed"
+	"fmt"
+	"html/template"
+	"io"
+	"sort"
+	"time"
+
+	"go.codecomet.dev/alkali/builder"
+	"go.codecomet.dev/alkali/builder/logs"
+	"go.codecomet.dev/alkali/builder/run"
+	"go.codecomet.dev/alkali/builder/stats"
+	"go.codecomet.dev/alkali/builder/trace"
+	"go.codecomet.dev/containers/digest"
+)
+
+//go:embed report.html.tmpl
+var htmlTemplate string
+
+// Past that, only the tail of a log stream makes it into the report
+const maxLogBytes = 1024 * 1024
+
+const (
+	nodeWidth  = 240
+	nodeHeight = 36
+	colGap     = 60
+	rowGap     = 16
+	labelChars = 32
+)
+
+// statusPending is for operations of the definition that never showed up in the trace
+const statusPending trace.Status = "pending"
+
+type htmlLog struct {
+	Stream    string
+	Content   string
+	Truncated bool
+}
+
+type htmlStep struct {
+	Name    string
+	Digest  digest.Digest
+	Status  trace.Status
+	Color   template.CSS
+	Error   string
+	Runtime string
+	// Gantt bar position, in percent of the whole run
+	Offset float64
+	Width  float64
+	Logs   []htmlLog
+}
+
+type htmlNode struct {
+	Label  string
+	Title  string
+	Color  template.CSS
+	X      int
+	Y      int
+	Width  int
+	Height int
+}
+
+type htmlEdge struct {
+	X1 int
+	Y1 int
+	X2 int
+	Y2 int
+}
+
+type htmlCache struct {
+	Total    int
+	Cached   int
+	Executed int
+	Errored  int
+	HitRatio string
+}
+
+type htmlEntry struct {
+	Key   string
+	Value string
+}
+
+type htmlReport struct {
+	ID          string
+	Started     string
+	Duration    string
+	Steps       []*htmlStep
+	Nodes       []*htmlNode
+	Edges       []*htmlEdge
+	GraphWidth  int
+	GraphHeight int
+	Cache       htmlCache
+	Warnings    []*trace.Warning
+	Exporter    []htmlEntry
+}
+
+// HTML writes a self-contained report of a run: timeline, graph of the definition, logs, cache statistics and exporter
+// response. Everything (styles included) is inlined, so the file can be shipped as is.
+func HTML(writer io.Writer, data *run.Data, trc *trace.Trace, exporterResponse map[string]string) error {
+	tmpl, err := template.New("report").Parse(htmlTemplate)
+	if err != nil {
+		return err
+	}
+
+	rep := &htmlReport{
+		ID:       data.ID,
+		Steps:    []*htmlStep{},
+		Nodes:    []*htmlNode{},
+		Edges:    []*htmlEdge{},
+		Warnings: trc.Warnings,
+		Exporter: []htmlEntry{},
+	}
+
+	var total time.Duration
+	if trc.Started != nil && trc.Completed != nil {
+		rep.Started = trc.Started.Format(time.RFC1123)
+		total = trc.Completed.Sub(*trc.Started)
+		rep.Duration = total.Round(time.Millisecond).String()
+	}
+
+	for _, action := range trc.Actions {
+		rep.Steps = append(rep.Steps, step(action, trc, total, data.Logs))
+	}
+
+	// Same numbers as printed at the end of the run
+	cacheStats := stats.FromTrace(trc, nil, nil)
+	rep.Cache = htmlCache{
+		Total:    cacheStats.Total,
+		Cached:   cacheStats.Cached,
+		Executed: cacheStats.Executed,
+		Errored:  cacheStats.Errored,
+	}
+
+	if cacheStats.Total > 0 {
+		rep.Cache.HitRatio = fmt.Sprintf("%.0f%%", cacheStats.HitRatio())
+	}
+
+	// Multi-platform runs have one definition per platform, which GetGraph merges
+	if data.Protobuf != nil || len(data.Platforms) > 0 {
+		nodes, err := data.GetGraph()
+		if err != nil {
+			return err
+		}
+
+		graph(rep, nodes, trc)
+	}
+
+	keys := make([]string, 0, len(exporterResponse))
+	for k := range exporterResponse {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		rep.Exporter = append(rep.Exporter, htmlEntry{Key: k, Value: exporterResponse[k]})
+	}
+
+	return tmpl.Execute(writer, rep)
+}
+
+func step(action *trace.Action, trc *trace.Trace, total time.Duration, store *logs.Store) *htmlStep {
+	stp := &htmlStep{
+		Name:    action.Name,
+		Digest:  action.Digest,
+		Status:  action.Status,
+		Color:   color(action.Status),
+		Error:   action.Error,
+		Runtime: action.Runtime.Round(time.Millisecond).String(),
+		Logs:    []htmlLog{},
+	}
+
+	if action.Started != nil && total > 0 {
+		stp.Offset = float64(action.Started.Sub(*trc.Started)) * 100 / float64(total)
+		stp.Width = float64(action.Runtime) * 100 / float64(total)
+	}
+
+	if store == nil {
+		return stp
+	}
+
+	for _, stream := range store.Streams(action.Digest) {
+		content, err := store.Get(action.Digest, stream)
+		if err != nil {
+			continue
+		}
+
+		hlog := htmlLog{Stream: "stdout", Content: string(content)}
+		if stream == logs.Stderr {
+			hlog.Stream = "stderr"
+		}
+
+		if len(content) > maxLogBytes {
+			hlog.Content = string(content[len(content)-maxLogBytes:])
+			hlog.Truncated = true
+		}
+
+		stp.Logs = append(stp.Logs, hlog)
+	}
+
+	return stp
+}
+
+// graph lays out the definition in columns, each operation being placed one column after its deepest input
+func graph(rep *htmlReport, nodes []*run.Node, trc *trace.Trace) {
+	byDigest := map[digest.Digest]*run.Node{}
+
+	for _, node := range nodes {
+		if !trc.Hidden(node.Digest) {
+			byDigest[node.Digest] = node
+		}
+	}
+
+	depths := map[digest.Digest]int{}
+
+	var depth func(dgst digest.Digest) int
+	depth = func(dgst digest.Digest) int {
+		if d, ok := depths[dgst]; ok {
+			return d
+		}
+
+		depths[dgst] = 0
+
+		for _, input := range byDigest[dgst].Inputs {
+			if _, ok := byDigest[input]; ok {
+				if d := depth(input) + 1; d > depths[dgst] {
+					depths[dgst] = d
+				}
+			}
+		}
+
+		return depths[dgst]
+	}
+
+	rows := map[int]int{}
+	positions := map[digest.Digest]*htmlNode{}
+
+	for _, node := range nodes {
+		if _, ok := byDigest[node.Digest]; !ok {
+			continue
+		}
+
+		col := depth(node.Digest)
+		status := statusPending
+
+		if action := trc.Action(node.Digest); action != nil {
+			status = action.Status
+		}
+
+		label := node.Label
+		if runes := []rune(label); len(runes) > labelChars {
+			label = string(runes[:labelChars-1]) + "…"
+		}
+
+		hnode := &htmlNode{
+			Label:  label,
+			Title:  fmt.Sprintf("%s\n%s\n%s", node.Label, node.Digest, status),
+			Color:  color(status),
+			X:      col * (nodeWidth + colGap),
+			Y:      rows[col] * (nodeHeight + rowGap),
+			Width:  nodeWidth,
+			Height: nodeHeight,
+		}
+		rows[col]++
+
+		positions[node.Digest] = hnode
+		rep.Nodes = append(rep.Nodes, hnode)
+
+		if right := hnode.X + nodeWidth; right > rep.GraphWidth {
+			rep.GraphWidth = right
+		}
+
+		if bottom := hnode.Y + nodeHeight; bottom > rep.GraphHeight {
+			rep.GraphHeight = bottom
+		}
+	}
+
+	for _, node := range nodes {
+		target, ok := positions[node.Digest]
+		if !ok {
+			continue
+		}
+
+		for _, input := range node.Inputs {
+			source, ok := positions[input]
+			if !ok {
+				continue
+			}
+
+			rep.Edges = append(rep.Edges, &htmlEdge{
+				X1: source.X + source.Width,
+				Y1: source.Y + source.Height/2,
+				X2: target.X,
+				Y2: target.Y + target.Height/2,
+			})
+		}
+	}
+}
+
+// Colors are built from constants only, hence safe to use as is in styles
+func color(status trace.Status) template.CSS {
+	rgb := builder.SolBase01
+
+	switch status {
+	case trace.StatusCompleted:
+		rgb = builder.SolGreen
+	case trace.StatusCached:
+		rgb = builder.SolBlue
+	case trace.StatusErrored:
+		rgb = builder.SolRed
+	case trace.StatusCancelled:
+		rgb = builder.SolMagenta
+	case trace.StatusStarted:
+		rgb = builder.SolYellow
+	case trace.StatusIgnored, statusPending:
+	}
+
+	return template.CSS(fmt.Sprintf("rgb(%s)", rgb)) //nolint:gosec
+}
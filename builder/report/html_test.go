@@ -0,0 +1,95 @@
+package report_test
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/moby/buildkit/client"
+	"github.com/moby/buildkit/client/llb"
+	ocispecs "github.com/opencontainers/image-spec/specs-go/v1"
+	"go.codecomet.dev/alkali/builder/report"
+	"go.codecomet.dev/alkali/builder/run"
+	"go.codecomet.dev/alkali/builder/trace"
+	"go.codecomet.dev/containers/digest"
+)
+
+func definition(t *testing.T, image string, platform ocispecs.Platform) *run.Platform {
+	t.Helper()
+
+	def, err := llb.Image(image).Marshal(context.Background(), llb.Platform(platform))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	proto := &bytes.Buffer{}
+	if err = llb.WriteTo(def, proto); err != nil {
+		t.Fatal(err)
+	}
+
+	return &run.Platform{Platform: platform, Protobuf: proto}
+}
+
+func vertex(name string, cached bool, failure string) *client.Vertex {
+	started := time.Now()
+	completed := started.Add(time.Second)
+
+	return &client.Vertex{
+		Digest:    digest.FromBytes([]byte(name)),
+		Name:      name,
+		Cached:    cached,
+		Started:   &started,
+		Completed: &completed,
+		Error:     failure,
+	}
+}
+
+func TestHTML(t *testing.T) {
+	t.Parallel()
+
+	data := run.New(nil)
+	data.Platforms = []*run.Platform{
+		definition(t, "alpine:3.18", ocispecs.Platform{OS: "linux", Architecture: "amd64"}),
+		definition(t, "busybox:1.36", ocispecs.Platform{OS: "linux", Architecture: "arm64"}),
+	}
+
+	t.Cleanup(func() {
+		_ = data.Close()
+	})
+
+	trc := trace.Ingest([]*client.SolveStatus{{
+		Vertexes: []*client.Vertex{
+			// Imports are not steps
+			vertex("importing cache manifest from registry.local/cache:main", false, ""),
+			vertex("[linux/amd64 1/2] FROM alpine", true, ""),
+			vertex("[linux/arm64 1/2] FROM busybox", false, ""),
+			vertex("[linux/arm64 2/2] RUN make", false, "exit code: 2"),
+		},
+	}}, nil)
+
+	out := &bytes.Buffer{}
+	if err := report.HTML(out, data, trc, map[string]string{"containerimage.digest": "sha256:abc"}); err != nil {
+		t.Fatal(err)
+	}
+
+	html := out.String()
+
+	for _, expected := range []string{
+		"<tr><th>Steps</th><td>3</td></tr>",
+		"<tr><th>Cached</th><td>1</td></tr>",
+		"<tr><th>Executed</th><td>2</td></tr>",
+		"<tr><th>Errored</th><td>1</td></tr>",
+		"<tr><th>Hit ratio</th><td>33%</td></tr>",
+		// Graph of both platforms
+		"<svg",
+		"docker-image://docker.io/library/alpine:3.18",
+		"docker-image://docker.io/library/busybox:1.36",
+		"containerimage.digest",
+	} {
+		if !strings.Contains(html, expected) {
+			t.Errorf("expected %q in the report", expected)
+		}
+	}
+}
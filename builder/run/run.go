@@ -3,17 +3,30 @@ package run
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
 
+	"github.com/containerd/containerd/platforms"
 	"github.com/moby/buildkit/identity"
+	"github.com/moby/buildkit/solver/pb"
+	ocispecs "github.com/opencontainers/image-spec/specs-go/v1"
+	"go.codecomet.dev/alkali/builder/logs"
+	"go.codecomet.dev/containers/digest"
 	"go.codecomet.dev/core/log"
 )
 
+var (
+	errUninitializedProtobuf = errors.New("uninitialized protobuf buffer")
+	errUnknownPlatform       = errors.New("platform not built by the run")
+)
+
 func New(proto *bytes.Buffer) *Data {
 	return &Data{
 		Protobuf: proto,
 		ID:       identity.NewID(),
 		Trace:    new(bytes.Buffer),
 		Meta:     new(bytes.Buffer),
+		Logs:     logs.NewStore("", logs.DefaultMemoryLimit),
 	}
 }
 
@@ -21,8 +34,87 @@ type Data struct {
 	ID       string
 	Trace    *bytes.Buffer
 	Protobuf *bytes.Buffer
-	Meta     *bytes.Buffer
-	Locals   map[string]string
+	// Platforms, if any, are built instead of Protobuf, and exported as a single multi-platform image
+	Platforms []*Platform
+	Meta      *bytes.Buffer
+	Locals    map[string]string
+	// Logs holds the (redacted) output of each vertex, per stream
+	Logs *logs.Store
+}
+
+// Platform is the definition built for one target platform.
+type Platform struct {
+	Platform ocispecs.Platform
+	Protobuf *bytes.Buffer
+}
+
+// ID identifies the platform in results (eg: `linux/arm64`).
+func (o *Platform) ID() string {
+	return platforms.Format(platforms.Normalize(o.Platform))
+}
+
+// definitions returns the definitions actually built
+func (o *Data) definitions() ([]*bytes.Buffer, error) {
+	if len(o.Platforms) == 0 {
+		if o.Protobuf == nil {
+			return nil, errUninitializedProtobuf
+		}
+
+		return []*bytes.Buffer{o.Protobuf}, nil
+	}
+
+	defs := []*bytes.Buffer{}
+
+	for _, plt := range o.Platforms {
+		if plt.Protobuf == nil {
+			return nil, errUninitializedProtobuf
+		}
+
+		defs = append(defs, plt.Protobuf)
+	}
+
+	return defs, nil
+}
+
+// readAll reads all built definitions, without consuming them. Operations shared between platforms are only
+// returned once.
+func (o *Data) readAll() ([]llbOp, error) {
+	defs, err := o.definitions()
+	if err != nil {
+		return nil, err
+	}
+
+	return readDefinitions(defs)
+}
+
+func readDefinitions(defs []*bytes.Buffer) ([]llbOp, error) {
+	seen := map[digest.Digest]bool{}
+	ops := []llbOp{}
+
+	for _, def := range defs {
+		read, err := readLLB(bytes.NewReader(def.Bytes()))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, op := range read {
+			if !seen[op.Digest] {
+				seen[op.Digest] = true
+				ops = append(ops, op)
+			}
+		}
+	}
+
+	return ops, nil
+}
+
+// Close releases the logs spilled to disk. Logs are not retrievable afterwards.
+func (o *Data) Close() error {
+	if o.Logs == nil {
+		return nil
+	}
+
+	return o.Logs.Close()
 }
 
 func (o *Data) GetJSON() *bytes.Buffer {
@@ -48,6 +140,103 @@ func (o *Data) GetJSON() *bytes.Buffer {
 	return out
 }
 
+type Node struct {
+	Digest digest.Digest
+	Label  string
+	Inputs []digest.Digest
+}
+
+// GetGraph returns the operations of the definition (of all platforms), with their inputs. Contrary to GetJSON and
+// GetDOT, this does not consume the protobuf buffer.
+func (o *Data) GetGraph() ([]*Node, error) {
+	ops, err := o.readAll()
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := []*Node{}
+
+	for _, op := range ops {
+		// The final vertex of a definition is only a pointer to the result
+		if op.Op.Op == nil {
+			continue
+		}
+
+		label, _ := attr(op.Digest, op.Op)
+		node := &Node{
+			Digest: op.Digest,
+			Label:  label,
+			Inputs: []digest.Digest{},
+		}
+
+		for _, input := range op.Op.Inputs {
+			node.Inputs = append(node.Inputs, input.Digest)
+		}
+
+		nodes = append(nodes, node)
+	}
+
+	return nodes, nil
+}
+
+// GetSources returns the identifiers of the source operations of the definition (eg: `docker-image://...`,
+// `git://...`, `local://...`). Like GetGraph, this does not consume the protobuf buffer.
+func (o *Data) GetSources() ([]string, error) {
+	ops, err := o.readAll()
+	if err != nil {
+		return nil, err
+	}
+
+	return sources(ops), nil
+}
+
+// GetPlatformSources is GetSources, for the definition of a single platform (by ID). Runs without platforms return all
+// their sources.
+func (o *Data) GetPlatformSources(id string) ([]string, error) {
+	if len(o.Platforms) == 0 {
+		return o.GetSources()
+	}
+
+	for _, plt := range o.Platforms {
+		if plt.ID() != id {
+			continue
+		}
+
+		if plt.Protobuf == nil {
+			return nil, errUninitializedProtobuf
+		}
+
+		ops, err := readDefinitions([]*bytes.Buffer{plt.Protobuf})
+		if err != nil {
+			return nil, err
+		}
+
+		return sources(ops), nil
+	}
+
+	return nil, fmt.Errorf("%w: %s", errUnknownPlatform, id)
+}
+
+// IsUnknownPlatform tells whether err is about a platform the run did not build.
+func IsUnknownPlatform(err error) bool {
+	return errors.Is(err, errUnknownPlatform)
+}
+
+func sources(ops []llbOp) []string {
+	sources := []string{}
+	seen := map[string]bool{}
+
+	// The same image is usually pulled once per platform
+	for _, op := range ops {
+		if src, ok := op.Op.Op.(*pb.Op_Source); ok && !seen[src.Source.Identifier] {
+			seen[src.Source.Identifier] = true
+			sources = append(sources, src.Source.Identifier)
+		}
+	}
+
+	return sources
+}
+
 func (o *Data) GetDOT() *bytes.Buffer {
 	out := new(bytes.Buffer)
 
@@ -0,0 +1,116 @@
+package signing
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+
+	"go.codecomet.dev/containers/digest"
+)
+
+var (
+	errPEM            = errors.New("no PEM block found")
+	errUnsupportedKey = errors.New("unsupported key type (must be ed25519 or ECDSA)")
+)
+
+// LoadPrivateKey reads a PEM encoded ed25519 or ECDSA private key (PKCS8, or SEC1 for ECDSA).
+func LoadPrivateKey(path string) (crypto.Signer, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var key any
+
+	if key, err = x509.ParsePKCS8PrivateKey(block.Bytes); err != nil {
+		if key, err = x509.ParseECPrivateKey(block.Bytes); err != nil {
+			return nil, fmt.Errorf("could not parse private key %s: %w", path, err)
+		}
+	}
+
+	switch signer := key.(type) {
+	case ed25519.PrivateKey:
+		return signer, nil
+	case *ecdsa.PrivateKey:
+		return signer, nil
+	}
+
+	return nil, fmt.Errorf("%w: %T", errUnsupportedKey, key)
+}
+
+// LoadPublicKey reads a PEM encoded (PKIX) ed25519 or ECDSA public key.
+func LoadPublicKey(path string) (crypto.PublicKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse public key %s: %w", path, err)
+	}
+
+	switch key.(type) {
+	case ed25519.PublicKey, *ecdsa.PublicKey:
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("%w: %T", errUnsupportedKey, key)
+}
+
+// KeyID identifies a public key: the digest of its PKIX encoding.
+func KeyID(key crypto.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return "", err
+	}
+
+	return digest.FromBytes(der).String(), nil
+}
+
+func readPEM(path string) (*pem.Block, error) {
+	byt, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(byt)
+	if block == nil {
+		return nil, fmt.Errorf("%w in %s", errPEM, path)
+	}
+
+	return block, nil
+}
+
+func sign(key crypto.Signer, payload []byte) ([]byte, error) {
+	switch key.Public().(type) {
+	case ed25519.PublicKey:
+		return key.Sign(rand.Reader, payload, crypto.Hash(0))
+	case *ecdsa.PublicKey:
+		sum := sha256.Sum256(payload)
+
+		return key.Sign(rand.Reader, sum[:], crypto.SHA256)
+	}
+
+	return nil, fmt.Errorf("%w: %T", errUnsupportedKey, key.Public())
+}
+
+func verify(key crypto.PublicKey, payload []byte, signature []byte) bool {
+	switch pub := key.(type) {
+	case ed25519.PublicKey:
+		return ed25519.Verify(pub, payload, signature)
+	case *ecdsa.PublicKey:
+		sum := sha256.Sum256(payload)
+
+		return ecdsa.VerifyASN1(pub, sum[:], signature)
+	}
+
+	return false
+}
@@ -0,0 +1,228 @@
+package signing
+
+import (
+	"context"
+	"crypto"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	ocispecs "github.com/opencontainers/image-spec/specs-go/v1"
+	"go.codecomet.dev/containers/digest"
+)
+
+const (
+	// ArtifactType identifies signature manifests among the referrers of an image
+	ArtifactType = "application/vnd.dev.codecomet.alkali.signature.v1+json"
+
+	payloadMediaType = "application/vnd.dev.codecomet.alkali.signature.payload.v1+json"
+	emptyConfig      = "{}"
+
+	signatureAnnotation = "dev.codecomet.alkali.signature"
+	keyIDAnnotation     = "dev.codecomet.alkali.key-id"
+)
+
+var (
+	errUnsigned = errors.New("no valid signature")
+	errPayload  = errors.New("signature payload does not match")
+)
+
+// payload is what is actually signed
+type payload struct {
+	Type   string        `json:"type"`
+	Digest digest.Digest `json:"digest"`
+}
+
+// Signature is a verified signature of an image.
+type Signature struct {
+	// Manifest is the signature artifact
+	Manifest digest.Digest
+	KeyID    string
+}
+
+// Sign signs the subject manifest (or index) digest with key, and stores the signature in store as an artifact
+// referring to subject. It returns the descriptor of the signature manifest.
+func Sign(
+	ctx context.Context,
+	store Store,
+	subject ocispecs.Descriptor,
+	key crypto.Signer,
+) (*ocispecs.Descriptor, error) {
+	keyID, err := KeyID(key.Public())
+	if err != nil {
+		return nil, err
+	}
+
+	byt, err := json.Marshal(payload{Type: ArtifactType, Digest: subject.Digest})
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := sign(key, byt)
+	if err != nil {
+		return nil, err
+	}
+
+	config := ocispecs.Descriptor{
+		MediaType: ArtifactType,
+		Digest:    digest.FromBytes([]byte(emptyConfig)),
+		Size:      int64(len(emptyConfig)),
+	}
+
+	layer := ocispecs.Descriptor{
+		MediaType: payloadMediaType,
+		Digest:    digest.FromBytes(byt),
+		Size:      int64(len(byt)),
+		Annotations: map[string]string{
+			signatureAnnotation: base64.StdEncoding.EncodeToString(signature),
+			keyIDAnnotation:     keyID,
+		},
+	}
+
+	manifest := ocispecs.Manifest{
+		MediaType: ocispecs.MediaTypeImageManifest,
+		Config:    config,
+		Layers:    []ocispecs.Descriptor{layer},
+		Subject: &ocispecs.Descriptor{
+			MediaType: subject.MediaType,
+			Digest:    subject.Digest,
+			Size:      subject.Size,
+		},
+	}
+	manifest.SchemaVersion = 2
+
+	manifestBytes, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	desc := ocispecs.Descriptor{
+		MediaType:    ocispecs.MediaTypeImageManifest,
+		ArtifactType: ArtifactType,
+		Digest:       digest.FromBytes(manifestBytes),
+		Size:         int64(len(manifestBytes)),
+	}
+
+	// Blobs first, so that the manifest never points to missing content
+	for _, blob := range []struct {
+		desc ocispecs.Descriptor
+		data []byte
+	}{{config, []byte(emptyConfig)}, {layer, byt}, {desc, manifestBytes}} {
+		if err = store.Push(ctx, blob.desc, blob.data); err != nil {
+			return nil, fmt.Errorf("could not push signature: %w", err)
+		}
+	}
+
+	if err = store.AddReferrer(ctx, subject.Digest, desc); err != nil {
+		return nil, fmt.Errorf("could not record signature: %w", err)
+	}
+
+	return &desc, nil
+}
+
+// Verify looks for a signature of subject made by any of keys. It errors if there is none.
+func Verify(ctx context.Context, store Store, subject digest.Digest, keys ...crypto.PublicKey) (*Signature, error) {
+	trusted := map[string]crypto.PublicKey{}
+
+	for _, key := range keys {
+		keyID, err := KeyID(key)
+		if err != nil {
+			return nil, err
+		}
+
+		trusted[keyID] = key
+	}
+
+	referrers, err := store.Referrers(ctx, subject)
+	if err != nil {
+		return nil, err
+	}
+
+	var lastErr error
+
+	for _, desc := range referrers {
+		if desc.ArtifactType != "" && desc.ArtifactType != ArtifactType {
+			continue
+		}
+
+		sig, err := verifyManifest(ctx, store, subject, desc, trusted)
+		if err != nil {
+			lastErr = err
+
+			continue
+		}
+
+		if sig != nil {
+			return sig, nil
+		}
+	}
+
+	if lastErr != nil {
+		return nil, fmt.Errorf("%w for %s: %v", errUnsigned, subject, lastErr)
+	}
+
+	return nil, fmt.Errorf("%w for %s", errUnsigned, subject)
+}
+
+// verifyManifest returns nil without error if the manifest is not a signature by one of the trusted keys
+func verifyManifest(
+	ctx context.Context,
+	store Store,
+	subject digest.Digest,
+	desc ocispecs.Descriptor,
+	trusted map[string]crypto.PublicKey,
+) (*Signature, error) {
+	byt, err := store.Fetch(ctx, desc)
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest ocispecs.Manifest
+	if err = json.Unmarshal(byt, &manifest); err != nil {
+		return nil, fmt.Errorf("could not unmarshal %s: %w", desc.Digest, err)
+	}
+
+	if manifest.Config.MediaType != ArtifactType || manifest.Subject == nil || manifest.Subject.Digest != subject {
+		return nil, nil //nolint:nilnil
+	}
+
+	for _, layer := range manifest.Layers {
+		key, ok := trusted[layer.Annotations[keyIDAnnotation]]
+		if !ok || layer.MediaType != payloadMediaType {
+			continue
+		}
+
+		signature, err := base64.StdEncoding.DecodeString(layer.Annotations[signatureAnnotation])
+		if err != nil {
+			return nil, fmt.Errorf("could not decode signature in %s: %w", desc.Digest, err)
+		}
+
+		data, err := store.Fetch(ctx, layer)
+		if err != nil {
+			return nil, err
+		}
+
+		// Content addressed stores are not trusted to have checked this
+		if digest.FromBytes(data) != layer.Digest {
+			return nil, fmt.Errorf("%w: %s content does not match its digest", errPayload, layer.Digest)
+		}
+
+		if !verify(key, data, signature) {
+			return nil, fmt.Errorf("%w: invalid signature in %s", errUnsigned, desc.Digest)
+		}
+
+		var signed payload
+		if err = json.Unmarshal(data, &signed); err != nil {
+			return nil, fmt.Errorf("could not unmarshal payload in %s: %w", desc.Digest, err)
+		}
+
+		if signed.Type != ArtifactType || signed.Digest != subject {
+			return nil, fmt.Errorf("%w: signed %s, expected %s", errPayload, signed.Digest, subject)
+		}
+
+		return &Signature{Manifest: desc.Digest, KeyID: layer.Annotations[keyIDAnnotation]}, nil
+	}
+
+	return nil, nil //nolint:nilnil
+}
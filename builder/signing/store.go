@@ -0,0 +1,232 @@
+package signing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/remotes"
+	"github.com/gofrs/flock"
+	ocispecs "github.com/opencontainers/image-spec/specs-go/v1"
+	"go.codecomet.dev/alkali/builder/registry"
+	"go.codecomet.dev/containers/digest"
+	"go.codecomet.dev/core/filesystem"
+)
+
+const (
+	indexFile       = "index.json"
+	lockFileSuffix  = ".lock"
+	blobsDir        = "blobs"
+	blobPermissions = 0o644
+
+	lockRetryDelay = 100 * time.Millisecond
+)
+
+var errLocked = errors.New("could not lock OCI layout")
+
+// Store holds images, and the artifacts referring to them.
+type Store interface {
+	Fetch(ctx context.Context, desc ocispecs.Descriptor) ([]byte, error)
+	Push(ctx context.Context, desc ocispecs.Descriptor, data []byte) error
+	// Referrers returns the manifests referring to subject
+	Referrers(ctx context.Context, subject digest.Digest) ([]ocispecs.Descriptor, error)
+	// AddReferrer records that the (already pushed) manifest desc refers to subject
+	AddReferrer(ctx context.Context, subject digest.Digest, desc ocispecs.Descriptor) error
+}
+
+// OCILayout is an OCI layout directory, as exported by buildkit. Referrers are listed in its index.
+type OCILayout struct {
+	Path string
+}
+
+func (o *OCILayout) blobPath(dgst digest.Digest) string {
+	return filepath.Join(o.Path, blobsDir, dgst.Algorithm().String(), dgst.Encoded())
+}
+
+func (o *OCILayout) Fetch(_ context.Context, desc ocispecs.Descriptor) ([]byte, error) {
+	return os.ReadFile(o.blobPath(desc.Digest))
+}
+
+func (o *OCILayout) Push(_ context.Context, desc ocispecs.Descriptor, data []byte) error {
+	path := o.blobPath(desc.Digest)
+
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), filesystem.DirPermissionsDefault); err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, blobPermissions)
+}
+
+func (o *OCILayout) index() (*ocispecs.Index, error) {
+	byt, err := os.ReadFile(filepath.Join(o.Path, indexFile))
+	if err != nil {
+		return nil, err
+	}
+
+	var index ocispecs.Index
+	if err = json.Unmarshal(byt, &index); err != nil {
+		return nil, fmt.Errorf("could not unmarshal %s: %w", indexFile, err)
+	}
+
+	return &index, nil
+}
+
+func (o *OCILayout) Referrers(ctx context.Context, subject digest.Digest) ([]ocispecs.Descriptor, error) {
+	index, err := o.index()
+	if err != nil {
+		return nil, err
+	}
+
+	ret := []ocispecs.Descriptor{}
+
+	for _, desc := range index.Manifests {
+		// Referrers are recorded with their artifact type, images are not worth reading
+		if desc.ArtifactType == "" || desc.MediaType != ocispecs.MediaTypeImageManifest {
+			continue
+		}
+
+		byt, err := o.Fetch(ctx, desc)
+		if err != nil {
+			return nil, err
+		}
+
+		var manifest ocispecs.Manifest
+		if err = json.Unmarshal(byt, &manifest); err != nil {
+			return nil, fmt.Errorf("could not unmarshal %s: %w", desc.Digest, err)
+		}
+
+		if manifest.Subject != nil && manifest.Subject.Digest == subject {
+			ret = append(ret, desc)
+		}
+	}
+
+	return ret, nil
+}
+
+// AddReferrer takes the same lock as buildkit on the index, so that it does not race with exports to the same layout.
+func (o *OCILayout) AddReferrer(ctx context.Context, _ digest.Digest, desc ocispecs.Descriptor) error {
+	lock := flock.New(filepath.Join(o.Path, indexFile+lockFileSuffix))
+
+	locked, err := lock.TryLockContext(ctx, lockRetryDelay)
+	if err != nil || !locked {
+		return fmt.Errorf("%w %s: %v", errLocked, o.Path, err)
+	}
+
+	defer func() {
+		_ = lock.Unlock()
+		// Same as buildkit
+		_ = os.RemoveAll(lock.Path())
+	}()
+
+	index, err := o.index()
+	if err != nil {
+		return err
+	}
+
+	for _, existing := range index.Manifests {
+		if existing.Digest == desc.Digest {
+			return nil
+		}
+	}
+
+	index.Manifests = append(index.Manifests, desc)
+
+	byt, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(o.Path, indexFile), byt, blobPermissions)
+}
+
+// Registry is a repository (eg: `docker.io/library/alpine`). Since not all registries implement the referrers API,
+// referrers are recorded using the fallback tag schema: an index tagged `<alg>-<digest>` lists them.
+type Registry struct {
+	Name     string
+	Resolver remotes.Resolver
+}
+
+func (o *Registry) Fetch(ctx context.Context, desc ocispecs.Descriptor) ([]byte, error) {
+	return registry.Fetch(ctx, o.Resolver, o.Name, desc)
+}
+
+func (o *Registry) Push(ctx context.Context, desc ocispecs.Descriptor, data []byte) error {
+	return registry.Push(ctx, o.Resolver, o.Name+"@"+desc.Digest.String(), desc, data)
+}
+
+func (o *Registry) referrersTag(subject digest.Digest) string {
+	return o.Name + ":" + strings.Replace(subject.String(), ":", "-", 1)
+}
+
+func (o *Registry) Referrers(ctx context.Context, subject digest.Digest) ([]ocispecs.Descriptor, error) {
+	index, err := o.referrers(ctx, subject)
+	if err != nil {
+		return nil, err
+	}
+
+	return index.Manifests, nil
+}
+
+func (o *Registry) referrers(ctx context.Context, subject digest.Digest) (*ocispecs.Index, error) {
+	index := &ocispecs.Index{
+		MediaType: ocispecs.MediaTypeImageIndex,
+		Manifests: []ocispecs.Descriptor{},
+	}
+	index.SchemaVersion = 2
+
+	_, desc, err := o.Resolver.Resolve(ctx, o.referrersTag(subject))
+	if errdefs.IsNotFound(err) {
+		return index, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	byt, err := o.Fetch(ctx, desc)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = json.Unmarshal(byt, index); err != nil {
+		return nil, fmt.Errorf("could not unmarshal referrers of %s: %w", subject, err)
+	}
+
+	return index, nil
+}
+
+func (o *Registry) AddReferrer(ctx context.Context, subject digest.Digest, desc ocispecs.Descriptor) error {
+	index, err := o.referrers(ctx, subject)
+	if err != nil {
+		return err
+	}
+
+	for _, existing := range index.Manifests {
+		if existing.Digest == desc.Digest {
+			return nil
+		}
+	}
+
+	index.Manifests = append(index.Manifests, desc)
+
+	byt, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+
+	return registry.Push(ctx, o.Resolver, o.referrersTag(subject), ocispecs.Descriptor{
+		MediaType: ocispecs.MediaTypeImageIndex,
+		Digest:    digest.FromBytes(byt),
+		Size:      int64(len(byt)),
+	}, byt)
+}
@@ -0,0 +1,122 @@
+package signing_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gofrs/flock"
+	ocispecs "github.com/opencontainers/image-spec/specs-go/v1"
+	"go.codecomet.dev/alkali/builder/internal/fakeregistry"
+	"go.codecomet.dev/alkali/builder/registry"
+	"go.codecomet.dev/alkali/builder/signing"
+	"go.codecomet.dev/containers/digest"
+)
+
+func TestOCILayoutAddReferrer(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "index.json"), []byte(`{"schemaVersion":2,"manifests":[]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	layout := &signing.OCILayout{Path: dir}
+	desc := ocispecs.Descriptor{
+		MediaType:    ocispecs.MediaTypeImageManifest,
+		ArtifactType: signing.ArtifactType,
+		Digest:       digest.FromBytes([]byte("signature")),
+	}
+
+	// Held by a concurrent export
+	lock := flock.New(filepath.Join(dir, "index.json.lock"))
+	if err := lock.Lock(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+
+	if err := layout.AddReferrer(ctx, digest.FromBytes([]byte("image")), desc); err == nil {
+		t.Fatal("expected the index lock to be honored")
+	}
+
+	if err := lock.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := layout.AddReferrer(context.Background(), digest.FromBytes([]byte("image")), desc); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	byt, err := os.ReadFile(filepath.Join(dir, "index.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var index ocispecs.Index
+	if err = json.Unmarshal(byt, &index); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(index.Manifests) != 1 || index.Manifests[0].Digest != desc.Digest {
+		t.Errorf("unexpected index %+v", index.Manifests)
+	}
+
+	if _, err = os.Stat(filepath.Join(dir, "index.json.lock")); !os.IsNotExist(err) {
+		t.Errorf("expected the lock file to be removed, got %v", err)
+	}
+}
+
+func TestRegistrySign(t *testing.T) {
+	t.Parallel()
+
+	reg, err := fakeregistry.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer reg.Close()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	byt := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.index.v1+json","manifests":[]}`)
+	subject := ocispecs.Descriptor{
+		MediaType: ocispecs.MediaTypeImageIndex,
+		Digest:    reg.PutManifest("org/app", "1.0", ocispecs.MediaTypeImageIndex, byt),
+		Size:      int64(len(byt)),
+	}
+
+	store := &signing.Registry{Name: reg.Host() + "/org/app", Resolver: registry.New().Resolver()}
+
+	// Signatures are not deterministic: the referrers index gets updated with the second one
+	for i := 0; i < 2; i++ {
+		if _, err = signing.Sign(context.Background(), store, subject, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	referrers, err := store.Referrers(context.Background(), subject.Digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(referrers) != 2 {
+		t.Errorf("expected both signatures to be listed, got %+v", referrers)
+	}
+
+	if _, err = signing.Verify(context.Background(), store, subject.Digest, key.Public()); err != nil {
+		t.Errorf("expected the signature to verify: %v", err)
+	}
+}
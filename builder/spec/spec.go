@@ -0,0 +1,159 @@
+package spec
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidSpec = errors.New("invalid spec")
+	ErrUnknownKey  = errors.New("unknown key")
+	ErrInvalidKey  = errors.New("invalid value")
+)
+
+const masked = "*****"
+
+// Attrs are the key/value pairs of a buildctl-style spec (eg: `type=registry,ref=foo,mode=max`), minus the type.
+type Attrs map[string]string
+
+// Parse splits a buildctl-style, comma separated spec into its type and attributes.
+func Parse(spec string) (string, Attrs, error) {
+	fields, err := csv.NewReader(strings.NewReader(spec)).Read()
+	if err != nil {
+		return "", nil, fmt.Errorf("%w %q: %s", ErrInvalidSpec, spec, err.Error())
+	}
+
+	typ := ""
+	attrs := Attrs{}
+
+	for _, field := range fields {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			return "", nil, fmt.Errorf("%w %q: %q is not a key=value pair", ErrInvalidSpec, spec, field)
+		}
+
+		key = strings.ToLower(strings.TrimSpace(key))
+		if key == "type" {
+			typ = value
+
+			continue
+		}
+
+		attrs[key] = value
+	}
+
+	if typ == "" {
+		return "", nil, fmt.Errorf("%w %q: missing type", ErrInvalidSpec, spec)
+	}
+
+	return typ, attrs, nil
+}
+
+// Format is the reverse of Parse. Values of secret keys are masked, as this is meant for logging.
+func Format(typ string, attrs map[string]string, secrets ...string) string {
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	fields := []string{"type=" + typ}
+
+	for _, k := range keys {
+		value := attrs[k]
+
+		for _, secret := range secrets {
+			if k == secret && value != "" {
+				value = masked
+			}
+		}
+
+		fields = append(fields, k+"="+value)
+	}
+
+	buf := new(bytes.Buffer)
+	writer := csv.NewWriter(buf)
+	_ = writer.Write(fields)
+	writer.Flush()
+
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+// Check returns an error if any attribute is not in the allowed list.
+func (o Attrs) Check(typ string, allowed ...string) error {
+	for key := range o {
+		known := false
+
+		for _, a := range allowed {
+			if key == a {
+				known = true
+
+				break
+			}
+		}
+
+		if !known {
+			return fmt.Errorf("%w %q for type %s (allowed: %s)", ErrUnknownKey, key, typ, strings.Join(allowed, ", "))
+		}
+	}
+
+	return nil
+}
+
+func (o Attrs) String(key string) string {
+	return o[key]
+}
+
+// StringEnv returns the attribute, or the value of the environment variable if the attribute is not set.
+func (o Attrs) StringEnv(key string, env string) string {
+	if value, ok := o[key]; ok && value != "" {
+		return value
+	}
+
+	return os.Getenv(env)
+}
+
+func (o Attrs) Bool(key string) (bool, error) {
+	value, ok := o[key]
+	if !ok || value == "" {
+		return false, nil
+	}
+
+	ret, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("%w for %s: %q is not a boolean", ErrInvalidKey, key, value)
+	}
+
+	return ret, nil
+}
+
+func (o Attrs) Int(key string) (int, error) {
+	value, ok := o[key]
+	if !ok || value == "" {
+		return 0, nil
+	}
+
+	ret, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("%w for %s: %q is not an integer", ErrInvalidKey, key, value)
+	}
+
+	return ret, nil
+}
+
+// List splits a semicolon separated attribute.
+func (o Attrs) List(key string) []string {
+	value, ok := o[key]
+	if !ok || value == "" {
+		return nil
+	}
+
+	return strings.Split(value, ";")
+}
@@ -0,0 +1,105 @@
+package spec_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"go.codecomet.dev/alkali/builder/spec"
+)
+
+func TestParse(t *testing.T) {
+	t.Parallel()
+
+	for _, test := range []struct {
+		value string
+		typ   string
+		attrs spec.Attrs
+	}{
+		{"type=registry,ref=foo,mode=max", "registry", spec.Attrs{"ref": "foo", "mode": "max"}},
+		{"TYPE=local, Dest=path", "local", spec.Attrs{"dest": "path"}},
+		{`type=docker,"name=app:1,app:latest"`, "docker", spec.Attrs{"name": "app:1,app:latest"}},
+		{"type=s3,name=a;b,prefix=", "s3", spec.Attrs{"name": "a;b", "prefix": ""}},
+		{"type=image,annotation.org.opencontainers.image.title=a=b", "image",
+			spec.Attrs{"annotation.org.opencontainers.image.title": "a=b"}},
+	} {
+		typ, attrs, err := spec.Parse(test.value)
+		if err != nil {
+			t.Errorf("%s: %v", test.value, err)
+
+			continue
+		}
+
+		if typ != test.typ || !reflect.DeepEqual(attrs, test.attrs) {
+			t.Errorf("%s: unexpected %s %v", test.value, typ, attrs)
+		}
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	t.Parallel()
+
+	for _, value := range []string{
+		"",
+		"ref=foo",
+		"type=registry,ref",
+		`type=docker,"name=app`,
+	} {
+		if _, _, err := spec.Parse(value); !errors.Is(err, spec.ErrInvalidSpec) {
+			t.Errorf("%q: expected an invalid spec error, got %v", value, err)
+		}
+	}
+}
+
+func TestFormat(t *testing.T) {
+	t.Parallel()
+
+	for _, attrs := range []spec.Attrs{
+		{},
+		{"ref": "foo", "mode": "max"},
+		{"name": "app:1,app:latest", "push": "true"},
+		{"annotation[linux/amd64].title": `say "hi"`},
+	} {
+		typ, parsed, err := spec.Parse(spec.Format("image", attrs))
+		if err != nil {
+			t.Errorf("%v: %v", attrs, err)
+
+			continue
+		}
+
+		if typ != "image" || !reflect.DeepEqual(parsed, attrs) {
+			t.Errorf("%v: round trip gave %s %v", attrs, typ, parsed)
+		}
+	}
+
+	formatted := spec.Format("s3", map[string]string{"bucket": "cache", "secret_access_key": "secret"}, "secret_access_key")
+	if formatted != "type=s3,bucket=cache,secret_access_key=*****" {
+		t.Errorf("expected secrets to be masked, got %s", formatted)
+	}
+}
+
+func TestAttrsCheck(t *testing.T) {
+	t.Parallel()
+
+	attrs := spec.Attrs{"ref": "foo", "bogus": "true"}
+
+	if err := attrs.Check("registry", "ref", "bogus"); err != nil {
+		t.Error(err)
+	}
+
+	if err := attrs.Check("registry", "ref"); !errors.Is(err, spec.ErrUnknownKey) {
+		t.Errorf("expected an unknown key error, got %v", err)
+	}
+}
+
+func TestAttrsStringEnv(t *testing.T) {
+	t.Setenv("SPEC_TEST_VALUE", "from-env")
+
+	attrs := spec.Attrs{"set": "value", "empty": ""}
+
+	for key, expected := range map[string]string{"set": "value", "empty": "from-env", "missing": "from-env"} {
+		if value := attrs.StringEnv(key, "SPEC_TEST_VALUE"); value != expected {
+			t.Errorf("%s: expected %q, got %q", key, expected, value)
+		}
+	}
+}
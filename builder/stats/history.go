@@ -0,0 +1,86 @@
+package stats
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"go.codecomet.dev/containers/digest"
+	"go.codecomet.dev/core/filesystem"
+)
+
+const historyPermissions = 0o600
+
+var errCorruptHistory = errors.New("corrupt cache history")
+
+// History remembers how long each vertex took the last time it was actually executed, so that time saved by cache
+// hits can be estimated. A nil History is valid, and remembers nothing.
+type History struct {
+	path      string
+	Durations map[digest.Digest]time.Duration `json:"durations"`
+}
+
+// LoadHistory reads the history file at path. A missing file yields an empty history. So does a corrupt one, along with
+// the error: saving it then replaces the corrupt file.
+func LoadHistory(path string) (*History, error) {
+	hist := &History{
+		path:      path,
+		Durations: map[digest.Digest]time.Duration{},
+	}
+
+	byt, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return hist, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err = json.Unmarshal(byt, hist); err != nil {
+		hist.Durations = map[digest.Digest]time.Duration{}
+
+		return hist, fmt.Errorf("%w %s: %v", errCorruptHistory, path, err) //nolint:errorlint
+	}
+
+	return hist, nil
+}
+
+func (o *History) Get(dgst digest.Digest) (time.Duration, bool) {
+	if o == nil {
+		return 0, false
+	}
+
+	duration, ok := o.Durations[dgst]
+
+	return duration, ok
+}
+
+func (o *History) Set(dgst digest.Digest, duration time.Duration) {
+	if o == nil {
+		return
+	}
+
+	o.Durations[dgst] = duration
+}
+
+func (o *History) Save() error {
+	if o == nil || o.path == "" {
+		return nil
+	}
+
+	byt, err := json.Marshal(o)
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(filepath.Dir(o.path), filesystem.DirPermissionsDefault); err != nil {
+		return err
+	}
+
+	return os.WriteFile(o.path, byt, historyPermissions)
+}
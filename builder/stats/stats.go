@@ -0,0 +1,210 @@
+package stats
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/moby/buildkit/client"
+	"go.codecomet.dev/alkali/builder/trace"
+	"go.codecomet.dev/alkali/builder/visibility"
+	"go.codecomet.dev/containers/digest"
+)
+
+const importPrefix = "importing cache manifest from "
+
+// Source is a cache import, as reported by buildkit.
+type Source struct {
+	ID     string
+	Loaded bool
+	Error  string
+	// Hits attributed to that source. Buildkit does not tell which import satisfied a given vertex, so this is only
+	// known (Attributed) when a single source was loaded.
+	Hits       int
+	Attributed bool
+}
+
+// Stats are best effort cache metrics for a run.
+type Stats struct {
+	Total    int
+	Cached   int
+	Executed int
+	// Errored steps are executed ones that failed
+	Errored int
+	// RemoteHits are cached vertexes that had to pull content (hence, came from an import). Cached results that did not
+	// need to be pulled cannot be told apart from local hits.
+	RemoteHits int
+	LocalHits  int
+	// TimeSaved is the sum of the last known uncached duration of every cached vertex
+	TimeSaved time.Duration
+	// Cached vertexes for which no previous duration was known
+	Unestimated   int
+	BytesImported int64
+	Sources       []*Source
+}
+
+// Compute derives cache metrics from the status stream of a run. History is optional: without it, time saved cannot
+// be estimated. Conversely, durations of vertexes executed during that run are recorded into it.
+func Compute(statuses []*client.SolveStatus, rules *visibility.Rules, history *History) *Stats {
+	return FromTrace(trace.Ingest(statuses, rules), statuses, history)
+}
+
+// FromTrace is Compute, for a trace already ingested from statuses. Without statuses, remote hits and imported bytes
+// cannot be told.
+func FromTrace(trc *trace.Trace, statuses []*client.SolveStatus, history *History) *Stats {
+	ret := &Stats{Sources: []*Source{}}
+	cached := map[digest.Digest]bool{}
+	sources := map[digest.Digest]*Source{}
+
+	for _, action := range trc.Actions {
+		if strings.HasPrefix(action.Name, importPrefix) {
+			src := &Source{
+				ID:     strings.TrimPrefix(action.Name, importPrefix),
+				Loaded: action.Status == trace.StatusCompleted || action.Status == trace.StatusCached,
+				Error:  action.Error,
+			}
+			sources[action.Digest] = src
+			ret.Sources = append(ret.Sources, src)
+
+			continue
+		}
+
+		ret.Total++
+
+		switch action.Status {
+		case trace.StatusCached:
+			ret.Cached++
+			cached[action.Digest] = true
+
+			if duration, ok := history.Get(action.Digest); ok {
+				ret.TimeSaved += duration
+			} else {
+				ret.Unestimated++
+			}
+		case trace.StatusCompleted:
+			ret.Executed++
+
+			history.Set(action.Digest, action.Runtime)
+		case trace.StatusErrored:
+			ret.Executed++
+			ret.Errored++
+		case trace.StatusCancelled, trace.StatusStarted, trace.StatusIgnored:
+		}
+	}
+
+	// Largest progress seen for each pull, per vertex. Only blob pulls (identified by the blob digest) are in bytes:
+	// other statuses may count anything.
+	pulled := map[digest.Digest]map[string]int64{}
+
+	for _, status := range statuses {
+		for _, vst := range status.Statuses {
+			if !cached[vst.Vertex] && sources[vst.Vertex] == nil || digest.Digest(vst.ID).Validate() != nil {
+				continue
+			}
+
+			if pulled[vst.Vertex] == nil {
+				pulled[vst.Vertex] = map[string]int64{}
+			}
+
+			size := vst.Current
+			if vst.Total > size {
+				size = vst.Total
+			}
+
+			if size > pulled[vst.Vertex][vst.ID] {
+				pulled[vst.Vertex][vst.ID] = size
+			}
+		}
+	}
+
+	for vtx, pulls := range pulled {
+		if cached[vtx] {
+			ret.RemoteHits++
+		}
+
+		for _, size := range pulls {
+			ret.BytesImported += size
+		}
+	}
+
+	ret.LocalHits = ret.Cached - ret.RemoteHits
+
+	loaded := []*Source{}
+
+	for _, src := range ret.Sources {
+		if src.Loaded {
+			loaded = append(loaded, src)
+		}
+	}
+
+	if len(loaded) == 1 {
+		loaded[0].Hits = ret.RemoteHits
+		loaded[0].Attributed = true
+	}
+
+	return ret
+}
+
+// HitRatio is the percentage of steps that were cached.
+func (o *Stats) HitRatio() float64 {
+	if o.Total == 0 {
+		return 0
+	}
+
+	return float64(o.Cached) * 100 / float64(o.Total) //nolint:gomnd
+}
+
+// Print renders a summary of the cache metrics.
+func Print(writer io.Writer, stats *Stats) {
+	if stats == nil || stats.Total == 0 {
+		return
+	}
+
+	fmt.Fprintf(writer, "\nCache: %d/%d steps cached (%.0f%%), %d executed\n", stats.Cached, stats.Total,
+		stats.HitRatio(), stats.Executed)
+	fmt.Fprintf(writer, " - local hits: %d, remote hits: %d\n", stats.LocalHits, stats.RemoteHits)
+
+	if stats.TimeSaved > 0 || stats.Unestimated > 0 {
+		fmt.Fprintf(writer, " - time saved: %s", stats.TimeSaved.Round(time.Millisecond))
+
+		if stats.Unestimated > 0 {
+			fmt.Fprintf(writer, " (%d steps with no known duration)", stats.Unestimated)
+		}
+
+		fmt.Fprintln(writer)
+	}
+
+	if stats.BytesImported > 0 {
+		fmt.Fprintf(writer, " - imported: %s\n", humanBytes(stats.BytesImported))
+	}
+
+	for _, src := range stats.Sources {
+		switch {
+		case !src.Loaded && src.Error != "":
+			fmt.Fprintf(writer, " - import %s: failed (%s)\n", src.ID, src.Error)
+		case !src.Loaded:
+			fmt.Fprintf(writer, " - import %s: not loaded\n", src.ID)
+		case !src.Attributed:
+			fmt.Fprintf(writer, " - import %s: loaded (hits cannot be attributed to one of several imports)\n", src.ID)
+		default:
+			fmt.Fprintf(writer, " - import %s: %d hits\n", src.ID, src.Hits)
+		}
+	}
+}
+
+func humanBytes(size int64) string {
+	const unit = 1024
+
+	if size < unit {
+		return fmt.Sprintf("%dB", size)
+	}
+
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f%ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,100 @@
+package stats_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/moby/buildkit/client"
+	"go.codecomet.dev/alkali/builder/stats"
+	"go.codecomet.dev/containers/digest"
+)
+
+func vertex(name string, cached bool) *client.Vertex {
+	started := time.Now()
+	completed := started.Add(time.Second)
+
+	return &client.Vertex{
+		Digest:    digest.FromBytes([]byte(name)),
+		Name:      name,
+		Cached:    cached,
+		Started:   &started,
+		Completed: &completed,
+	}
+}
+
+func TestComputeSources(t *testing.T) {
+	t.Parallel()
+
+	step := vertex("[build 1/1] RUN make", true)
+	layer := digest.FromBytes([]byte("layer"))
+
+	statuses := []*client.SolveStatus{{
+		Vertexes: []*client.Vertex{
+			vertex("importing cache manifest from registry.local/cache:main", false),
+			vertex("importing cache manifest from registry.local/cache:feature", false),
+			step,
+		},
+		Statuses: []*client.VertexStatus{
+			{ID: layer.String(), Vertex: step.Digest, Current: 512, Total: 1024},
+			{ID: layer.String(), Vertex: step.Digest, Current: 1024, Total: 1024},
+			// Not a pull: not counted as bytes
+			{ID: "copying files", Vertex: step.Digest, Current: 4000},
+		},
+	}}
+
+	res := stats.Compute(statuses, nil, nil)
+
+	if res.BytesImported != 1024 {
+		t.Errorf("expected 1024 bytes imported, got %d", res.BytesImported)
+	}
+
+	if res.RemoteHits != 1 || len(res.Sources) != 2 {
+		t.Fatalf("unexpected stats %+v", res)
+	}
+
+	for _, src := range res.Sources {
+		if src.Attributed {
+			t.Errorf("hits can not be attributed with several sources: %+v", src)
+		}
+	}
+
+	out := &bytes.Buffer{}
+	stats.Print(out, res)
+
+	if strings.Contains(out.String(), "0 hits") {
+		t.Errorf("unattributed hits printed as 0:\n%s", out)
+	}
+}
+
+func TestLoadCorruptHistory(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "history.json")
+	if err := os.WriteFile(path, []byte("{not json"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	hist, err := stats.LoadHistory(path)
+	if err == nil || hist == nil {
+		t.Fatalf("expected an empty history along with an error, got %v, %v", hist, err)
+	}
+
+	dgst := digest.FromBytes([]byte("step"))
+	hist.Set(dgst, time.Second)
+
+	if err = hist.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	if hist, err = stats.LoadHistory(path); err != nil {
+		t.Fatal(err)
+	}
+
+	if duration, ok := hist.Get(dgst); !ok || duration != time.Second {
+		t.Errorf("expected the corrupt history to be replaced, got %v", hist.Durations)
+	}
+}
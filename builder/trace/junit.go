@@ -0,0 +1,169 @@
+package trace
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// How many lines of logs to include in a failure
+const junitLogTailLines = 50
+
+// ANSI escape sequences: CSI (colors, cursor movement), OSC (titles, links), and two-byte escapes
+var ansiEscape = regexp.MustCompile(`\x1b(\[[0-?]*[ -/]*[@-~]|\][^\x07\x1b]*(\x07|\x1b\\)|[@-Z\\-_])`)
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Skipped  int              `xml:"skipped,attr"`
+	Time     string           `xml:"time,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Errors    int             `xml:"errors,attr"`
+	Skipped   int             `xml:"skipped,attr"`
+	Time      string          `xml:"time,attr"`
+	Timestamp string          `xml:"timestamp,attr,omitempty"`
+	Cases     []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name       string           `xml:"name,attr"`
+	Classname  string           `xml:"classname,attr"`
+	Time       string           `xml:"time,attr"`
+	Properties *junitProperties `xml:"properties,omitempty"`
+	Failure    *junitFailure    `xml:"failure,omitempty"`
+	Skipped    *junitSkipped    `xml:"skipped,omitempty"`
+}
+
+type junitProperties struct {
+	Properties []junitProperty `xml:"property"`
+}
+
+type junitProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type junitFailure struct {
+	Message  string `xml:"message,attr"`
+	Type     string `xml:"type,attr"`
+	Contents string `xml:",cdata"`
+}
+
+type junitSkipped struct {
+	Message string `xml:"message,attr"`
+}
+
+// JUnit writes the trace as a JUnit XML report, with one testcase per (visible) action.
+// Errored actions are reported as failures (with the tail of their logs), cancelled ones as skipped.
+func (o *Trace) JUnit(writer io.Writer, name string) error {
+	suite := junitTestSuite{
+		Name:  name,
+		Cases: []junitTestCase{},
+	}
+
+	if o.Started != nil {
+		suite.Timestamp = o.Started.UTC().Format(time.RFC3339)
+	}
+
+	for _, action := range o.Actions {
+		testCase := junitTestCase{
+			Name:      action.Name,
+			Classname: name,
+			Time:      seconds(action.Runtime),
+			Properties: &junitProperties{
+				Properties: []junitProperty{
+					{Name: "digest", Value: action.Digest.String()},
+				},
+			},
+		}
+
+		if action.Cached {
+			testCase.Properties.Properties = append(testCase.Properties.Properties, junitProperty{
+				Name:  "cached",
+				Value: "true",
+			})
+		}
+
+		switch action.Status {
+		case StatusErrored:
+			testCase.Failure = &junitFailure{
+				Message:  sanitizeXML(action.Error),
+				Type:     string(StatusErrored),
+				Contents: sanitizeXML(action.LogTail(junitLogTailLines)),
+			}
+			suite.Failures++
+		case StatusCancelled:
+			testCase.Skipped = &junitSkipped{
+				Message: sanitizeXML(action.Error),
+			}
+			suite.Skipped++
+		case StatusIgnored, StatusStarted, StatusCompleted, StatusCached:
+		}
+
+		suite.Cases = append(suite.Cases, testCase)
+		suite.Tests++
+	}
+
+	var total time.Duration
+	if o.Started != nil && o.Completed != nil {
+		total = o.Completed.Sub(*o.Started)
+	}
+
+	suite.Time = seconds(total)
+
+	report := junitTestSuites{
+		Name:     name,
+		Tests:    suite.Tests,
+		Failures: suite.Failures,
+		Skipped:  suite.Skipped,
+		Time:     suite.Time,
+		Suites:   []junitTestSuite{suite},
+	}
+
+	if _, err := io.WriteString(writer, xml.Header); err != nil {
+		return err
+	}
+
+	enc := xml.NewEncoder(writer)
+	enc.Indent("", "  ")
+
+	if err := enc.Encode(report); err != nil {
+		return err
+	}
+
+	_, err := fmt.Fprintln(writer)
+
+	return err
+}
+
+func seconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
+
+// sanitizeXML strips ANSI escape sequences, and replaces characters XML 1.0 does not allow. encoding/xml does not
+// escape CDATA sections, and CI parsers reject the whole report over a single escape byte.
+func sanitizeXML(value string) string {
+	value = ansiEscape.ReplaceAllString(strings.ToValidUTF8(value, "\uFFFD"), "")
+
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r == '\t' || r == '\n' || r == '\r':
+			return r
+		case r < 0x20, r >= 0xD800 && r <= 0xDFFF, r == 0xFFFE, r == 0xFFFF:
+			return '\uFFFD'
+		}
+
+		return r
+	}, value)
+}
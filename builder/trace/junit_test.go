@@ -0,0 +1,77 @@
+package trace_test
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/moby/buildkit/client"
+	"go.codecomet.dev/alkali/builder/trace"
+	"go.codecomet.dev/containers/digest"
+)
+
+func TestJUnitColoredLogs(t *testing.T) {
+	t.Parallel()
+
+	dgst := digest.FromBytes([]byte("vertex"))
+	started := time.Now()
+	completed := started.Add(time.Second)
+
+	statuses := []*client.SolveStatus{{
+		Vertexes: []*client.Vertex{{
+			Digest:    dgst,
+			Name:      "[build 1/1] RUN make",
+			Started:   &started,
+			Completed: &completed,
+			Error:     "\x1b[31mprocess did not complete successfully\x1b[0m",
+		}},
+		Logs: []*client.VertexLog{{
+			Vertex: dgst,
+			Stream: 2,
+			Data: []byte("\x1b[1;31merror:\x1b[0m undefined reference\n" +
+				"\x1b]0;title\x07bell\x07 backspace\x08 nul\x00 invalid\xff ]]> done\n"),
+		}},
+	}}
+
+	out := &bytes.Buffer{}
+	if err := trace.Ingest(statuses, nil).JUnit(out, "suite"); err != nil {
+		t.Fatal(err)
+	}
+
+	report := out.String()
+	if strings.ContainsAny(report, "\x1b\x07\x08\x00") {
+		t.Fatalf("report contains control characters: %q", report)
+	}
+
+	var parsed struct {
+		Suites []struct {
+			Cases []struct {
+				Failure *struct {
+					Message  string `xml:"message,attr"`
+					Contents string `xml:",chardata"`
+				} `xml:"failure"`
+			} `xml:"testcase"`
+		} `xml:"testsuite"`
+	}
+
+	// The standard decoder rejects characters XML 1.0 does not allow, same as CI parsers
+	if err := xml.Unmarshal(out.Bytes(), &parsed); err != nil {
+		t.Fatalf("report is not valid XML: %v\n%s", err, report)
+	}
+
+	failure := parsed.Suites[0].Cases[0].Failure
+	if failure == nil {
+		t.Fatal("expected a failure")
+	}
+
+	if failure.Message != "process did not complete successfully" {
+		t.Errorf("unexpected message %q", failure.Message)
+	}
+
+	want := "error: undefined reference\nbell� backspace� nul� invalid� ]]> done"
+	if failure.Contents != want {
+		t.Errorf("unexpected contents %q, want %q", failure.Contents, want)
+	}
+}
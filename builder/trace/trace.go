@@ -1,98 +1,175 @@
 package trace
 
 import (
+	"context"
 	"strings"
+	"time"
 
 	"github.com/moby/buildkit/client"
-	"github.com/moby/buildkit/client/llb"
+	"go.codecomet.dev/alkali/builder/visibility"
+	"go.codecomet.dev/containers/digest"
 )
 
-/*
-import (
-	"encoding/json"
-	"github.com/moby/buildkit/client"
+// Keep at most that much log output per action - this is only meant to provide context on failures
+const maxLogTail = 64 * 1024
+
+type Status string
+
+const (
+	StatusIgnored   Status = "ignored"
+	StatusStarted   Status = "started"
+	StatusCompleted Status = "completed"
+	StatusErrored   Status = "errored"
+	StatusCancelled Status = "cancelled"
+	StatusCached    Status = "cached"
 )
 
-type V = client.VertexStatus
+type Action struct {
+	Name      string
+	Digest    digest.Digest
+	Parents   []digest.Digest
+	Cached    bool
+	Status    Status
+	Started   *time.Time
+	Completed *time.Time
+	Runtime   time.Duration
+	Error     string
+
+	logs []byte
+}
+
+// LogTail returns at most the last `lines` lines of output recorded for that action.
+func (a *Action) LogTail(lines int) string {
+	tail := strings.TrimRight(string(a.logs), "\n")
+	if tail == "" || lines <= 0 {
+		return ""
+	}
+
+	split := strings.Split(tail, "\n")
+	if len(split) > lines {
+		split = split[len(split)-lines:]
+	}
 
-func Ingest(w []byte) *client.VertexStatus {
-	solved := &client.SolveStatus{}
-	_ = json.Unmarshal(w, solved)
-	// o.op.Run.Trace.Bytes()
+	return strings.Join(split, "\n")
+}
 
+type Trace struct {
+	Started   *time.Time
+	Completed *time.Time
+	// Actions, in the order they were first seen
+	Actions []*Action
+	// Warnings, deduplicated, in the order they were first seen
+	Warnings []*Warning
+
+	rules    *visibility.Rules
+	hidden   map[digest.Digest]bool
+	actions  map[digest.Digest]*Action
+	warnings map[string]*Warning
 }
-*/
 
-func Ingest(state llb.State, statusSlice []*client.SolveStatus) {
-	//marshmallow := state.Marshal(context.TODO())
+// Ingest builds the trace model from the status stream of a run. Vertexes hidden by the rules (along with their logs
+// and warnings) are left out. Nil rules hide nothing.
+func Ingest(statusSlice []*client.SolveStatus, rules *visibility.Rules) *Trace {
+	trc := &Trace{
+		Actions:  []*Action{},
+		Warnings: []*Warning{},
+		rules:    rules,
+		hidden:   map[digest.Digest]bool{},
+		actions:  map[digest.Digest]*Action{},
+		warnings: map[string]*Warning{},
+	}
 
 	for _, status := range statusSlice {
-		if status.Vertexes != nil {
-			for _, vtx := range status.Vertexes {
-				vertex(vtx)
-			}
+		for _, vtx := range status.Vertexes {
+			trc.vertex(vtx)
+		}
+
+		for _, vlog := range status.Logs {
+			trc.log(vlog)
+		}
+
+		for _, vwarn := range status.Warnings {
+			trc.warning(vwarn)
 		}
 	}
+
+	return trc
 }
 
-func vertex(vtx *client.Vertex) {
-	// Some actions are hidden away - either CodeComet internal shenanigans, or actions authors who want to hide their own internal dance
-	if vtx.ProgressGroup != nil && vtx.ProgressGroup.Weak {
-		return
-	}
+// Hidden tells whether a vertex was left out of the trace because of the visibility rules.
+func (o *Trace) Hidden(dgst digest.Digest) bool {
+	return o.hidden[dgst]
+}
+
+// Action returns the action for that digest, or nil if the vertex is unknown or hidden.
+func (o *Trace) Action(dgst digest.Digest) *Action {
+	return o.actions[dgst]
+}
+
+func (o *Trace) vertex(vtx *client.Vertex) {
+	if o.rules.Hidden(vtx) {
+		o.hidden[vtx.Digest] = true
 
-	// Currently, BK leaks internal operations. The right solution is to finish replacing the default client with our own. Short term, very dirty hack by ignoring anything that starts with "[auth] "
-	if strings.HasPrefix(vtx.Name, "[auth] ") {
 		return
 	}
-	/*
-
-		if (!this.actionsObject[vertice.Digest]) {
-			const action = <model.BuildAction>{
-			id     : createId('html_id'),
-				name   : vertice.Name,
-					digest : vertice.Digest,
-					cached : false,
-					status : ActionStatus.Ignored,
-			};
-
-			if (vertice.Inputs) {
-				action.buildParents = vertice.Inputs;
-			}
-
-			this.actionsObject[vertice.Digest] = action;
+
+	action, ok := o.actions[vtx.Digest]
+	if !ok {
+		action = &Action{
+			Name:    vtx.Name,
+			Digest:  vtx.Digest,
+			Parents: vtx.Inputs,
+			Status:  StatusIgnored,
 		}
+		o.actions[vtx.Digest] = action
+		o.Actions = append(o.Actions, action)
+	}
+
+	if vtx.Started != nil {
+		action.Started = vtx.Started
+		action.Status = StatusStarted
 
-		if (vertice.Started){
-			this.actionsObject[vertice.Digest].started = Date.parse(vertice.Started);
+		if o.Started == nil || action.Started.Before(*o.Started) {
+			o.Started = action.Started
+		}
+	}
 
-			if (!this.started || this.actionsObject[vertice.Digest].started < this.started) {
-				this.started = this.actionsObject[vertice.Digest].started;
-			}
+	if vtx.Completed != nil {
+		action.Completed = vtx.Completed
+		action.Status = StatusCompleted
 
-			this.actionsObject[vertice.Digest].status = ActionStatus.Started;
+		if action.Started != nil {
+			action.Runtime = action.Completed.Sub(*action.Started)
 		}
 
-		if (vertice.Completed) {
-			this.actionsObject[vertice.Digest].completed = Date.parse(vertice.Completed);
-			this.actionsObject[vertice.Digest].runtime = this.actionsObject[vertice.Digest].completed - this.actionsObject[vertice.Digest].started;
-			this.actionsObject[vertice.Digest].status = ActionStatus.Completed;
+		if o.Completed == nil || action.Completed.After(*o.Completed) {
+			o.Completed = action.Completed
 		}
+	}
 
-		if (vertice.Error) {
-			this.actionsObject[vertice.Digest].error = vertice.Error;
-			this.actionsObject[vertice.Digest].status = ActionStatus.Errored;
+	if vtx.Error != "" {
+		action.Error = vtx.Error
+		action.Status = StatusErrored
 
-			if (vertice.Error.match(/did not complete successfully: exit code: 137: context canceled: context canceled$/)) {
-			this.actionsObject[vertice.Digest].status = ActionStatus.Cancelled;
-			}
+		if strings.HasSuffix(vtx.Error, context.Canceled.Error()) {
+			action.Status = StatusCancelled
 		}
+	}
 
-		if (vertice.Cached) {
-			this.actionsObject[vertice.Digest].cached = true;
-			this.actionsObject[vertice.Digest].status = ActionStatus.Cached;
-		}
+	if vtx.Cached {
+		action.Cached = true
+		action.Status = StatusCached
+	}
+}
 
+func (o *Trace) log(vlog *client.VertexLog) {
+	action, ok := o.actions[vlog.Vertex]
+	if !ok {
+		return
+	}
 
-	*/
+	action.logs = append(action.logs, vlog.Data...)
+	if len(action.logs) > maxLogTail {
+		action.logs = action.logs[len(action.logs)-maxLogTail:]
+	}
 }
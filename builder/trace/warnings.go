@@ -0,0 +1,139 @@
+package trace
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/moby/buildkit/client"
+	"go.codecomet.dev/containers/digest"
+)
+
+type Range struct {
+	StartLine      int
+	StartCharacter int
+	EndLine        int
+	EndCharacter   int
+}
+
+type Warning struct {
+	Level    int
+	Short    string
+	Detail   []string
+	URL      string
+	Filename string
+	Ranges   []Range
+	// All vertexes that reported that same warning
+	Vertexes []digest.Digest
+}
+
+func (o *Warning) key() string {
+	ranges := []string{}
+	for _, r := range o.Ranges {
+		ranges = append(ranges, fmt.Sprintf("%d:%d-%d:%d", r.StartLine, r.StartCharacter, r.EndLine, r.EndCharacter))
+	}
+
+	return strings.Join([]string{
+		fmt.Sprint(o.Level),
+		o.Short,
+		strings.Join(o.Detail, "\n"),
+		o.URL,
+		o.Filename,
+		strings.Join(ranges, ","),
+	}, "\x00")
+}
+
+// Location returns a human readable file:line reference, if the warning carries one.
+func (o *Warning) Location() string {
+	if o.Filename == "" {
+		return ""
+	}
+
+	if len(o.Ranges) == 0 {
+		return o.Filename
+	}
+
+	return fmt.Sprintf("%s:%d", o.Filename, o.Ranges[0].StartLine)
+}
+
+func (o *Trace) warning(vwarn *client.VertexWarning) {
+	if o.hidden[vwarn.Vertex] {
+		return
+	}
+
+	warn := &Warning{
+		Level:    vwarn.Level,
+		Short:    string(vwarn.Short),
+		Detail:   []string{},
+		URL:      vwarn.URL,
+		Ranges:   []Range{},
+		Vertexes: []digest.Digest{vwarn.Vertex},
+	}
+
+	for _, detail := range vwarn.Detail {
+		warn.Detail = append(warn.Detail, string(detail))
+	}
+
+	if vwarn.SourceInfo != nil {
+		warn.Filename = vwarn.SourceInfo.Filename
+	}
+
+	for _, rng := range vwarn.Range {
+		warn.Ranges = append(warn.Ranges, Range{
+			StartLine:      int(rng.Start.Line),
+			StartCharacter: int(rng.Start.Character),
+			EndLine:        int(rng.End.Line),
+			EndCharacter:   int(rng.End.Character),
+		})
+	}
+
+	key := warn.key()
+
+	existing, ok := o.warnings[key]
+	if !ok {
+		o.warnings[key] = warn
+		o.Warnings = append(o.Warnings, warn)
+
+		return
+	}
+
+	for _, vtx := range existing.Vertexes {
+		if vtx == vwarn.Vertex {
+			return
+		}
+	}
+
+	existing.Vertexes = append(existing.Vertexes, vwarn.Vertex)
+}
+
+// PrintWarnings renders a summary of warnings, meant to be displayed once the progress output is done.
+func PrintWarnings(writer io.Writer, warnings []*Warning) {
+	if len(warnings) == 0 {
+		return
+	}
+
+	plural := ""
+	if len(warnings) > 1 {
+		plural = "s"
+	}
+
+	fmt.Fprintf(writer, "\n%d warning%s found:\n", len(warnings), plural)
+
+	for _, warn := range warnings {
+		fmt.Fprintf(writer, " - %s", warn.Short)
+
+		if location := warn.Location(); location != "" {
+			fmt.Fprintf(writer, " (%s)", location)
+		}
+
+		fmt.Fprintln(writer)
+
+		for _, detail := range warn.Detail {
+			fmt.Fprintf(writer, "   %s\n", detail)
+		}
+
+		if warn.URL != "" {
+			fmt.Fprintf(writer, "   More info: %s\n", warn.URL)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package trace_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/moby/buildkit/client"
+	"github.com/moby/buildkit/solver/pb"
+	"go.codecomet.dev/alkali/builder/trace"
+	"go.codecomet.dev/alkali/builder/visibility"
+	"go.codecomet.dev/containers/digest"
+)
+
+func dockerfileWarning(vtx digest.Digest, line int32) *client.VertexWarning {
+	return &client.VertexWarning{
+		Vertex:     vtx,
+		Level:      1,
+		Short:      []byte("FromAsCasing: 'as' and 'FROM' keywords' casing do not match"),
+		Detail:     [][]byte{[]byte("Use consistent casing.")},
+		URL:        "https://docs.docker.com/go/dockerfile/rule/from-as-casing/",
+		SourceInfo: &pb.SourceInfo{Filename: "Dockerfile"},
+		Range:      []*pb.Range{{Start: pb.Position{Line: line}, End: pb.Position{Line: line}}},
+	}
+}
+
+func TestWarningsDeduplicated(t *testing.T) {
+	t.Parallel()
+
+	first := digest.FromBytes([]byte("first"))
+	second := digest.FromBytes([]byte("second"))
+
+	statuses := []*client.SolveStatus{
+		{
+			Vertexes: []*client.Vertex{{Digest: first, Name: "[linux/amd64] load Dockerfile"}},
+			Warnings: []*client.VertexWarning{dockerfileWarning(first, 1)},
+		},
+		{
+			// Same warning, reported again by the same vertex, then by the other platform
+			Vertexes: []*client.Vertex{{Digest: second, Name: "[linux/arm64] load Dockerfile"}},
+			Warnings: []*client.VertexWarning{
+				dockerfileWarning(first, 1),
+				dockerfileWarning(second, 1),
+				// Another line is another warning
+				dockerfileWarning(second, 3),
+			},
+		},
+	}
+
+	warnings := trace.Ingest(statuses, nil).Warnings
+
+	if len(warnings) != 2 {
+		t.Fatalf("expected 2 warnings, got %d", len(warnings))
+	}
+
+	if len(warnings[0].Vertexes) != 2 || warnings[0].Vertexes[0] != first || warnings[0].Vertexes[1] != second {
+		t.Errorf("expected the first warning to list both vertexes once, got %v", warnings[0].Vertexes)
+	}
+
+	if warnings[0].Location() != "Dockerfile:1" || warnings[1].Location() != "Dockerfile:3" {
+		t.Errorf("unexpected locations %q and %q", warnings[0].Location(), warnings[1].Location())
+	}
+
+	out := &bytes.Buffer{}
+	trace.PrintWarnings(out, warnings)
+
+	if !strings.Contains(out.String(), "2 warnings found:") {
+		t.Errorf("expected a warning count, got %q", out.String())
+	}
+
+	if strings.Count(out.String(), "FromAsCasing") != 2 {
+		t.Errorf("expected each warning to be printed once, got %q", out.String())
+	}
+}
+
+func TestWarningsFailedBuild(t *testing.T) {
+	t.Parallel()
+
+	dgst := digest.FromBytes([]byte("vertex"))
+	hidden := digest.FromBytes([]byte("hidden"))
+	started := time.Now()
+	completed := started.Add(time.Second)
+
+	statuses := []*client.SolveStatus{{
+		Vertexes: []*client.Vertex{
+			{
+				Digest:    dgst,
+				Name:      "[build 1/1] RUN make",
+				Started:   &started,
+				Completed: &completed,
+				Error:     "process did not complete successfully: exit code: 2",
+			},
+			{Digest: hidden, Name: "[auth] docker.io"},
+		},
+		Warnings: []*client.VertexWarning{
+			{Vertex: dgst, Short: []byte("make: warning: Clock skew detected")},
+			{Vertex: hidden, Short: []byte("hidden warning")},
+		},
+	}}
+
+	trc := trace.Ingest(statuses, visibility.Default())
+
+	if trc.Action(dgst) == nil || trc.Action(dgst).Status != trace.StatusErrored {
+		t.Fatal("expected the vertex to be errored")
+	}
+
+	if len(trc.Warnings) != 1 || trc.Warnings[0].Short != "make: warning: Clock skew detected" {
+		t.Fatalf("expected the warning of the failed vertex only, got %v", trc.Warnings)
+	}
+
+	out := &bytes.Buffer{}
+	trace.PrintWarnings(out, trc.Warnings)
+
+	expected := "\n1 warning found:\n - make: warning: Clock skew detected\n"
+	if out.String() != expected {
+		t.Errorf("expected %q, got %q", expected, out.String())
+	}
+}
+
+func TestPrintWarningsEmpty(t *testing.T) {
+	t.Parallel()
+
+	out := &bytes.Buffer{}
+	trace.PrintWarnings(out, nil)
+
+	if out.Len() != 0 {
+		t.Errorf("expected no output, got %q", out.String())
+	}
+}
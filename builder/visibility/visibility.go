@@ -0,0 +1,95 @@
+package visibility
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/moby/buildkit/client"
+	"go.codecomet.dev/containers/digest"
+)
+
+// Rule decides whether a vertex should be hidden away from the user.
+type Rule interface {
+	Hides(vtx *client.Vertex) bool
+}
+
+// Prefix hides vertexes whose name starts with the given string.
+type Prefix string
+
+func (o Prefix) Hides(vtx *client.Vertex) bool {
+	return strings.HasPrefix(vtx.Name, string(o))
+}
+
+// Pattern hides vertexes whose name matches the regular expression.
+type Pattern struct {
+	*regexp.Regexp
+}
+
+func NewPattern(expr string) (*Pattern, error) {
+	reg, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Pattern{Regexp: reg}, nil
+}
+
+func (o *Pattern) Hides(vtx *client.Vertex) bool {
+	return o.MatchString(vtx.Name)
+}
+
+// ProgressGroup hides vertexes belonging to the progress group with that ID.
+type ProgressGroup string
+
+func (o ProgressGroup) Hides(vtx *client.Vertex) bool {
+	return vtx.ProgressGroup != nil && vtx.ProgressGroup.Id == string(o)
+}
+
+// WeakProgressGroups hides vertexes that are part of any weak progress group.
+type WeakProgressGroups struct{}
+
+func (o WeakProgressGroups) Hides(vtx *client.Vertex) bool {
+	return vtx.ProgressGroup != nil && vtx.ProgressGroup.Weak
+}
+
+// Digests hides a specific set of vertexes.
+type Digests map[digest.Digest]bool
+
+func (o Digests) Hides(vtx *client.Vertex) bool {
+	return o[vtx.Digest]
+}
+
+type Rules struct {
+	Rules []Rule
+	// ShowHidden disables all rules - typically for debugging
+	ShowHidden bool
+}
+
+// Default hides weak progress groups (either CodeComet internal shenanigans, or actions authors who want to hide their
+// own internal dance) and "[auth] " operations, as BK currently leaks these internal operations.
+func Default() *Rules {
+	return &Rules{
+		Rules: []Rule{
+			WeakProgressGroups{},
+			Prefix("[auth] "),
+		},
+	}
+}
+
+func (o *Rules) Add(rule ...Rule) {
+	o.Rules = append(o.Rules, rule...)
+}
+
+func (o *Rules) Hidden(vtx *client.Vertex) bool {
+	if o == nil || o.ShowHidden {
+		return false
+	}
+
+	for _, rule := range o.Rules {
+		if rule.Hides(vtx) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,159 @@
+package visibility_test
+
+import (
+	"testing"
+
+	"github.com/moby/buildkit/client"
+	"github.com/moby/buildkit/solver/pb"
+	"go.codecomet.dev/alkali/builder/visibility"
+	"go.codecomet.dev/containers/digest"
+)
+
+func TestRules(t *testing.T) {
+	t.Parallel()
+
+	pattern, err := visibility.NewPattern(`^\[internal\] load (metadata|build context)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hiddenDigest := digest.FromBytes([]byte("hidden"))
+
+	tests := []struct {
+		name     string
+		rules    *visibility.Rules
+		vertex   *client.Vertex
+		expected bool
+	}{
+		{
+			name:     "nil rules",
+			vertex:   &client.Vertex{Name: "[auth] docker.io"},
+			expected: false,
+		},
+		{
+			name:     "prefix",
+			rules:    &visibility.Rules{Rules: []visibility.Rule{visibility.Prefix("[auth] ")}},
+			vertex:   &client.Vertex{Name: "[auth] library/alpine:pull token for registry-1.docker.io"},
+			expected: true,
+		},
+		{
+			name:     "prefix no match",
+			rules:    &visibility.Rules{Rules: []visibility.Rule{visibility.Prefix("[auth] ")}},
+			vertex:   &client.Vertex{Name: "RUN echo [auth] "},
+			expected: false,
+		},
+		{
+			name:     "pattern",
+			rules:    &visibility.Rules{Rules: []visibility.Rule{pattern}},
+			vertex:   &client.Vertex{Name: "[internal] load metadata for docker.io/library/alpine:3.18"},
+			expected: true,
+		},
+		{
+			name:     "pattern no match",
+			rules:    &visibility.Rules{Rules: []visibility.Rule{pattern}},
+			vertex:   &client.Vertex{Name: "[internal] load .dockerignore"},
+			expected: false,
+		},
+		{
+			name:  "progress group",
+			rules: &visibility.Rules{Rules: []visibility.Rule{visibility.ProgressGroup("group")}},
+			vertex: &client.Vertex{
+				Name:          "RUN make",
+				ProgressGroup: &pb.ProgressGroup{Id: "group"},
+			},
+			expected: true,
+		},
+		{
+			name:  "other progress group",
+			rules: &visibility.Rules{Rules: []visibility.Rule{visibility.ProgressGroup("group")}},
+			vertex: &client.Vertex{
+				Name:          "RUN make",
+				ProgressGroup: &pb.ProgressGroup{Id: "other"},
+			},
+			expected: false,
+		},
+		{
+			name:     "progress group without group",
+			rules:    &visibility.Rules{Rules: []visibility.Rule{visibility.ProgressGroup("")}},
+			vertex:   &client.Vertex{Name: "RUN make"},
+			expected: false,
+		},
+		{
+			name:  "weak progress group",
+			rules: &visibility.Rules{Rules: []visibility.Rule{visibility.WeakProgressGroups{}}},
+			vertex: &client.Vertex{
+				Name:          "RUN make",
+				ProgressGroup: &pb.ProgressGroup{Id: "group", Weak: true},
+			},
+			expected: true,
+		},
+		{
+			name:  "strong progress group",
+			rules: &visibility.Rules{Rules: []visibility.Rule{visibility.WeakProgressGroups{}}},
+			vertex: &client.Vertex{
+				Name:          "RUN make",
+				ProgressGroup: &pb.ProgressGroup{Id: "group"},
+			},
+			expected: false,
+		},
+		{
+			name:     "digests",
+			rules:    &visibility.Rules{Rules: []visibility.Rule{visibility.Digests{hiddenDigest: true}}},
+			vertex:   &client.Vertex{Digest: hiddenDigest, Name: "RUN make"},
+			expected: true,
+		},
+		{
+			name:     "digests no match",
+			rules:    &visibility.Rules{Rules: []visibility.Rule{visibility.Digests{hiddenDigest: true}}},
+			vertex:   &client.Vertex{Digest: digest.FromBytes([]byte("visible")), Name: "RUN make"},
+			expected: false,
+		},
+		{
+			name:     "default",
+			rules:    visibility.Default(),
+			vertex:   &client.Vertex{Name: "[auth] docker.io"},
+			expected: true,
+		},
+		{
+			name: "show hidden",
+			rules: &visibility.Rules{
+				Rules:      []visibility.Rule{visibility.Prefix("[auth] "), visibility.WeakProgressGroups{}},
+				ShowHidden: true,
+			},
+			vertex: &client.Vertex{
+				Name:          "[auth] docker.io",
+				ProgressGroup: &pb.ProgressGroup{Id: "group", Weak: true},
+			},
+			expected: false,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if hidden := tt.rules.Hidden(tt.vertex); hidden != tt.expected {
+				t.Errorf("expected hidden to be %t, got %t", tt.expected, hidden)
+			}
+		})
+	}
+}
+
+func TestRulesAdd(t *testing.T) {
+	t.Parallel()
+
+	rules := &visibility.Rules{}
+	vtx := &client.Vertex{Name: "[internal] load build definition"}
+
+	if rules.Hidden(vtx) {
+		t.Fatal("empty rules should not hide anything")
+	}
+
+	rules.Add(visibility.Prefix("[internal] "))
+
+	if !rules.Hidden(vtx) {
+		t.Fatal("added rule should hide the vertex")
+	}
+}
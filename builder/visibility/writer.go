@@ -0,0 +1,75 @@
+package visibility
+
+import (
+	"github.com/moby/buildkit/client"
+	"github.com/moby/buildkit/util/progress/progresswriter"
+	"go.codecomet.dev/containers/digest"
+)
+
+// Filter wraps a progress writer so that hidden vertexes (and their statuses, logs and warnings) never reach it.
+func Filter(in progresswriter.Writer, rules *Rules) progresswriter.Writer {
+	writer := &filterWriter{Writer: in, status: make(chan *client.SolveStatus)}
+	hidden := map[digest.Digest]bool{}
+
+	go func() {
+		defer close(in.Status())
+
+		for status := range writer.status {
+			status = filter(status, rules, hidden)
+
+			select {
+			case in.Status() <- status:
+			case <-in.Done():
+			}
+		}
+	}()
+
+	return writer
+}
+
+type filterWriter struct {
+	progresswriter.Writer
+	status chan *client.SolveStatus
+}
+
+func (o *filterWriter) Status() chan *client.SolveStatus {
+	return o.status
+}
+
+func filter(status *client.SolveStatus, rules *Rules, hidden map[digest.Digest]bool) *client.SolveStatus {
+	if rules == nil || rules.ShowHidden {
+		return status
+	}
+
+	ret := &client.SolveStatus{}
+
+	for _, vtx := range status.Vertexes {
+		if rules.Hidden(vtx) {
+			hidden[vtx.Digest] = true
+
+			continue
+		}
+
+		ret.Vertexes = append(ret.Vertexes, vtx)
+	}
+
+	for _, vst := range status.Statuses {
+		if !hidden[vst.Vertex] {
+			ret.Statuses = append(ret.Statuses, vst)
+		}
+	}
+
+	for _, vlog := range status.Logs {
+		if !hidden[vlog.Vertex] {
+			ret.Logs = append(ret.Logs, vlog)
+		}
+	}
+
+	for _, vwarn := range status.Warnings {
+		if !hidden[vwarn.Vertex] {
+			ret.Warnings = append(ret.Warnings, vwarn)
+		}
+	}
+
+	return ret
+}
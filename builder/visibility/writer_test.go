@@ -0,0 +1,115 @@
+package visibility_test
+
+import (
+	"testing"
+
+	"github.com/moby/buildkit/client"
+	"go.codecomet.dev/alkali/builder/visibility"
+	"go.codecomet.dev/containers/digest"
+)
+
+type fakeWriter struct {
+	status chan *client.SolveStatus
+	done   chan struct{}
+}
+
+func (o *fakeWriter) Done() <-chan struct{} {
+	return o.done
+}
+
+func (o *fakeWriter) Err() error {
+	return nil
+}
+
+func (o *fakeWriter) Status() chan *client.SolveStatus {
+	return o.status
+}
+
+// collect sends the statuses through Filter, and returns what reaches the wrapped writer
+func collect(rules *visibility.Rules, statuses ...*client.SolveStatus) []*client.SolveStatus {
+	fake := &fakeWriter{status: make(chan *client.SolveStatus), done: make(chan struct{})}
+	writer := visibility.Filter(fake, rules)
+
+	go func() {
+		for _, status := range statuses {
+			writer.Status() <- status
+		}
+
+		close(writer.Status())
+	}()
+
+	received := []*client.SolveStatus{}
+	for status := range fake.status {
+		received = append(received, status)
+	}
+
+	return received
+}
+
+func TestFilter(t *testing.T) {
+	t.Parallel()
+
+	hidden := digest.FromBytes([]byte("hidden"))
+	visible := digest.FromBytes([]byte("visible"))
+	rules := &visibility.Rules{Rules: []visibility.Rule{visibility.Prefix("[auth] ")}}
+
+	received := collect(rules,
+		&client.SolveStatus{
+			Vertexes: []*client.Vertex{
+				{Digest: hidden, Name: "[auth] docker.io"},
+				{Digest: visible, Name: "RUN make"},
+			},
+		},
+		// Later statuses only reference the vertex by digest
+		&client.SolveStatus{
+			Statuses: []*client.VertexStatus{
+				{Vertex: hidden, ID: "token"},
+				{Vertex: visible, ID: "step"},
+			},
+			Logs: []*client.VertexLog{
+				{Vertex: hidden, Data: []byte("secret\n")},
+				{Vertex: visible, Data: []byte("building\n")},
+			},
+			Warnings: []*client.VertexWarning{
+				{Vertex: hidden, Short: []byte("hidden warning")},
+				{Vertex: visible, Short: []byte("visible warning")},
+			},
+		},
+	)
+
+	if len(received) != 2 {
+		t.Fatalf("expected 2 statuses, got %d", len(received))
+	}
+
+	if len(received[0].Vertexes) != 1 || received[0].Vertexes[0].Digest != visible {
+		t.Errorf("expected only the visible vertex, got %v", received[0].Vertexes)
+	}
+
+	if len(received[1].Statuses) != 1 || received[1].Statuses[0].Vertex != visible {
+		t.Errorf("expected only the visible vertex status, got %v", received[1].Statuses)
+	}
+
+	if len(received[1].Logs) != 1 || string(received[1].Logs[0].Data) != "building\n" {
+		t.Errorf("expected only the visible vertex logs, got %v", received[1].Logs)
+	}
+
+	if len(received[1].Warnings) != 1 || received[1].Warnings[0].Vertex != visible {
+		t.Errorf("expected only the visible vertex warnings, got %v", received[1].Warnings)
+	}
+}
+
+func TestFilterShowHidden(t *testing.T) {
+	t.Parallel()
+
+	hidden := digest.FromBytes([]byte("hidden"))
+	rules := &visibility.Rules{Rules: []visibility.Rule{visibility.Prefix("[auth] ")}, ShowHidden: true}
+
+	received := collect(rules, &client.SolveStatus{
+		Vertexes: []*client.Vertex{{Digest: hidden, Name: "[auth] docker.io"}},
+		Logs:     []*client.VertexLog{{Vertex: hidden, Data: []byte("token\n")}},
+	})
+
+	if len(received) != 1 || len(received[0].Vertexes) != 1 || len(received[0].Logs) != 1 {
+		t.Fatalf("expected everything to go through, got %v", received)
+	}
+}
@@ -3,8 +3,12 @@ module go.codecomet.dev/alkali
 go 1.19
 
 require (
+	github.com/containerd/containerd v1.6.20
 	github.com/docker/cli v24.0.1+incompatible
+	github.com/gofrs/flock v0.8.1
+	github.com/klauspost/compress v1.16.0
 	github.com/moby/buildkit v0.11.6
+	github.com/opencontainers/image-spec v1.1.0-rc2.0.20221005185240-3a7f492d3f1b
 	go.codecomet.dev/containers v0.0.0-20230518210341-2bc4a43b9c54
 	go.codecomet.dev/core v0.0.0-20230613214154-1e4d30c3fec1
 	golang.org/x/sync v0.2.0
@@ -13,7 +17,6 @@ require (
 require (
 	github.com/Microsoft/go-winio v0.5.2 // indirect
 	github.com/containerd/console v1.0.3 // indirect
-	github.com/containerd/containerd v1.6.20 // indirect
 	github.com/containerd/continuity v0.3.0 // indirect
 	github.com/containerd/typeurl v1.0.2 // indirect
 	github.com/docker/distribution v2.8.1+incompatible // indirect
@@ -23,7 +26,6 @@ require (
 	github.com/getsentry/sentry-go/otel v0.21.0 // indirect
 	github.com/go-logr/logr v1.2.4 // indirect
 	github.com/go-logr/stdr v1.2.2 // indirect
-	github.com/gofrs/flock v0.8.1 // indirect
 	github.com/gogo/googleapis v1.4.1 // indirect
 	github.com/gogo/protobuf v1.3.2 // indirect
 	github.com/golang/protobuf v1.5.2 // indirect
@@ -37,7 +39,6 @@ require (
 	github.com/moby/sys/signal v0.7.0 // indirect
 	github.com/morikuni/aec v1.0.0 // indirect
 	github.com/opencontainers/go-digest v1.0.0 // indirect
-	github.com/opencontainers/image-spec v1.1.0-rc2.0.20221005185240-3a7f492d3f1b // indirect
 	github.com/pkg/errors v0.9.1 // indirect
 	github.com/rs/zerolog v1.29.1 // indirect
 	github.com/sirupsen/logrus v1.9.0 // indirect